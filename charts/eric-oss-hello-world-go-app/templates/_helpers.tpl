@@ -312,6 +312,23 @@ Define upper limit for TerminationGracePeriodSeconds
 {{- end -}}
 {{- end -}}
 
+{{/*
+Define the time the app waits with a failing health check before draining
+*/}}
+{{- define "eric-oss-hello-world-go-app.shutdownDelay" -}}
+{{- printf "%ds" (int (.Values.shutdownDelaySeconds | default 0)) -}}
+{{- end -}}
+
+{{/*
+Define the drain timeout so that the shutdown delay plus draining fits within
+terminationGracePeriodSeconds, keeping a small margin before the kubelet sends SIGKILL
+*/}}
+{{- define "eric-oss-hello-world-go-app.shutdownTimeout" -}}
+{{- $grace := int (.Values.terminationGracePeriodSeconds | default 30) -}}
+{{- $delay := int (.Values.shutdownDelaySeconds | default 0) -}}
+{{- printf "%ds" (max 1 (sub (sub $grace $delay) 2)) -}}
+{{- end -}}
+
 {{/*
 Define the role reference for security policy
 */}}
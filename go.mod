@@ -18,4 +18,4 @@ require (
 	google.golang.org/protobuf v1.34.2 // indirect
 )
 
-go 1.20
+go 1.21
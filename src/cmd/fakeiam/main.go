@@ -0,0 +1,65 @@
+// Command fakeiam serves an in-process fake IAM, so the app can be run locally without a Keycloak.
+//
+//	go run ./src/cmd/fakeiam -client hello-world:secret
+//	IAM_BASE_URL=http://localhost:8081 IAM_CLIENT_ID=hello-world IAM_CLIENT_SECRET=secret go run ./src
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+)
+
+// clientFlags collects the repeatable -client flag
+type clientFlags []string
+
+func (c *clientFlags) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *clientFlags) Set(value string) error {
+	for _, client := range strings.Split(value, ",") {
+		if _, _, ok := strings.Cut(client, ":"); !ok {
+			return fmt.Errorf("client %q is not id:secret", client)
+		}
+		*c = append(*c, client)
+	}
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8081", "address to listen on")
+	realm := flag.String("realm", "master", "realm to serve")
+	lifetime := flag.Duration("lifetime", 5*time.Minute, "lifetime of the issued tokens")
+	roles := flag.String("roles", "", "comma separated realm roles granted to every client")
+	var clients clientFlags
+	flag.Var(&clients, "client", "client as id:secret, repeatable or comma separated")
+	flag.Parse()
+
+	_, port, err := net.SplitHostPort(*addr)
+	if err != nil {
+		log.Fatalf("Invalid address %q: %v", *addr, err)
+	}
+	baseURL := "http://localhost:" + port
+	iam := fakeiam.New(baseURL, *realm)
+	iam.SetTokenLifetime(*lifetime)
+	var grantedRoles []string
+	if *roles != "" {
+		grantedRoles = strings.Split(*roles, ",")
+	}
+	for _, client := range clients {
+		id, secret, _ := strings.Cut(client, ":")
+		iam.AddClient(id, fakeiam.Client{Secret: secret, Roles: grantedRoles})
+		log.Printf("Registered client %s", id)
+	}
+
+	log.Printf("Fake IAM for realm %s, start the app with IAM_BASE_URL=%s IAM_REALM=%s", *realm, baseURL, *realm)
+	server := &http.Server{Addr: *addr, Handler: iam, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(server.ListenAndServe())
+}
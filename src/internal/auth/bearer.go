@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+	log "eric-oss-hello-world-go-app/src/internal/logging"
+	"eric-oss-hello-world-go-app/src/internal/network"
+)
+
+var (
+	// ErrIssuer the token was issued by another IAM or realm
+	ErrIssuer = errors.New("unexpected token issuer")
+	// ErrAudience the token is not meant for this app
+	ErrAudience = errors.New("unexpected token audience")
+	// ErrInactive the IAM reports that the token is not active
+	ErrInactive = errors.New("token is not active")
+)
+
+// TokenClaims are the claims of an access token issued by the IAM
+type TokenClaims struct {
+	jwt.Claims
+	Scope             string `json:"scope,omitempty"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	ClientID          string `json:"client_id,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	RealmAccess       struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	ResourceAccess map[string]struct {
+		Roles []string `json:"roles"`
+	} `json:"resource_access"`
+}
+
+// String returns the subject and the client the token was issued to, which is what is logged for a caller
+func (c *TokenClaims) String() string {
+	client := c.AuthorizedParty
+	if client == "" {
+		client = c.ClientID
+	}
+	if client == "" {
+		return c.Subject
+	}
+	return c.Subject + " (" + client + ")"
+}
+
+// Scopes returns the space separated scope claim as a list
+func (c *TokenClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasRole reports whether role is a realm role of the token, or a role of the given client
+func (c *TokenClaims) HasRole(client, role string) bool {
+	return contains(c.RealmAccess.Roles, role) || (client != "" && contains(c.ResourceAccess[client].Roles, role))
+}
+
+// TokenClaimsFromContext returns the validated bearer token claims of the request, if any
+func TokenClaimsFromContext(ctx context.Context) (*TokenClaims, bool) {
+	claims, ok := ctx.Value(tokenClaimsKey).(*TokenClaims)
+	return claims, ok
+}
+
+// BearerTokenFromContext returns the validated bearer token of the request, for example to exchange it
+// for a token calling other services on behalf of the caller
+func BearerTokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(bearerTokenKey).(string)
+	return token, ok
+}
+
+// Requirement lists the scopes and roles a bearer token must all have
+type Requirement struct {
+	Scopes []string
+	Roles  []string
+}
+
+// BearerPolicy validates the bearer tokens issued by the IAM
+type BearerPolicy struct {
+	Keys *KeySet
+	// Issuer returns the expected iss claim
+	Issuer func(ctx context.Context) (string, error)
+	// Audience must be in the aud claim when it is set, its client roles are accepted as well as realm roles
+	Audience string
+	// ClockSkew is allowed when checking exp, nbf and iat
+	ClockSkew time.Duration
+	// Introspect, when set, asks the IAM for the claims of tokens that are not JWTs,
+	// returning ErrInactive for tokens that are not active
+	Introspect func(ctx context.Context, token string) (*TokenClaims, error)
+}
+
+// Validate verifies the signature and the claims of token. Tokens that are not JWTs are introspected
+// when Introspect is set.
+func (p BearerPolicy) Validate(ctx context.Context, token string) (*TokenClaims, error) {
+	parsed, err := jwt.Parse(token)
+	if errors.Is(err, jwt.ErrMalformed) && p.Introspect != nil {
+		claims, err := p.Introspect(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		// an introspection response does not have to name the issuer nor the expiry,
+		// the IAM has just checked the token is active
+		if err := p.validateClaims(ctx, claims, claims.Issuer != "", claims.ExpiresAt != 0); err != nil {
+			return nil, err
+		}
+		return claims, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.Keys.Key(ctx, parsed.Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := parsed.Verify(key); err != nil {
+		return nil, err
+	}
+
+	claims := &TokenClaims{}
+	if err := parsed.Claims(claims); err != nil {
+		return nil, err
+	}
+	if err := p.validateClaims(ctx, claims, true, true); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p BearerPolicy) validateClaims(ctx context.Context, claims *TokenClaims, checkIssuer, checkTime bool) error {
+	if checkTime {
+		if err := claims.ValidateTime(time.Now(), p.ClockSkew); err != nil {
+			return err
+		}
+	}
+	if checkIssuer {
+		issuer, err := p.Issuer(ctx)
+		if err != nil {
+			return err
+		}
+		if claims.Issuer != issuer {
+			return fmt.Errorf("%w %q", ErrIssuer, claims.Issuer)
+		}
+	}
+	if p.Audience != "" && !claims.Audience.Contains(p.Audience) {
+		return fmt.Errorf("%w %q", ErrAudience, strings.Join(claims.Audience, " "))
+	}
+	return nil
+}
+
+// Require rejects requests without a valid bearer token with 401, and requests whose token lacks
+// a scope or role of requirement with 403. An accepted token and its claims are put on the request context.
+func (p BearerPolicy) Require(requirement Requirement, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		token, ok := bearerToken(req)
+		if !ok {
+			requestLog(req).Warning("Rejected request without bearer token")
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := p.Validate(req.Context(), token)
+		if err != nil && !rejectsToken(err) {
+			requestLog(req).WithError(err).Error("Could not validate bearer token")
+			http.Error(resp, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			requestLog(req).WithError(err).Warning("Rejected bearer token")
+			resp.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		if missing := p.missing(claims, requirement); missing != "" {
+			requestLog(req).WithSubject(claims.String()).WithField("missing", missing).
+				Warning("Rejected bearer token lacking a scope or role")
+			resp.Header().Set("WWW-Authenticate",
+				`Bearer error="insufficient_scope", scope="`+strings.Join(requirement.Scopes, " ")+`"`)
+			http.Error(resp, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), tokenClaimsKey, claims)
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(ctx, bearerTokenKey, token)))
+	})
+}
+
+// missing returns the first scope or role of requirement the token does not have
+func (p BearerPolicy) missing(claims *TokenClaims, requirement Requirement) string {
+	scopes := claims.Scopes()
+	for _, scope := range requirement.Scopes {
+		if !contains(scopes, scope) {
+			return "scope " + scope
+		}
+	}
+	for _, role := range requirement.Roles {
+		if !claims.HasRole(p.Audience, role) {
+			return "role " + role
+		}
+	}
+	return ""
+}
+
+// rejectsToken tells a token that is invalid from a failure to get the keys or the issuer
+func rejectsToken(err error) bool {
+	return errors.Is(err, jwt.ErrMalformed) || errors.Is(err, jwt.ErrSignature) ||
+		errors.Is(err, jwt.ErrUnsupportedKey) || errors.Is(err, jwt.ErrExpired) ||
+		errors.Is(err, jwt.ErrNoExpiry) || errors.Is(err, jwt.ErrNotValidYet) || errors.Is(err, ErrUnknownKey) ||
+		errors.Is(err, ErrIssuer) || errors.Is(err, ErrAudience) || errors.Is(err, ErrInactive)
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	return token, ok && strings.EqualFold(scheme, "Bearer") && token != ""
+}
+
+// requestLog Returns an entry logging the path and origin of req to the security facility,
+// with the fields of its context
+func requestLog(req *http.Request) *log.Entry {
+	return log.WithContext(req.Context()).WithFacility(log.FacilitySecurity).
+		WithFields(log.Fields{"path": req.URL.Path, "origin": network.GetIPInfo(req)})
+}
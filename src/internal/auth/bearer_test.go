@@ -0,0 +1,224 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/auth"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIssuer = "https://iam.example.com/auth/realms/master"
+
+type testIAM struct {
+	key *ecdsa.PrivateKey
+	err error
+}
+
+func newTestIAM(t *testing.T) *testIAM {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return &testIAM{key: key}
+}
+
+func (i *testIAM) fetch(context.Context) (*jwt.JWKS, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	return &jwt.JWKS{Keys: []jwt.JWK{{
+		KeyType: "EC", KeyID: "key1", Curve: "P-256",
+		X: base64.RawURLEncoding.EncodeToString(i.key.X.FillBytes(make([]byte, 32))),
+		Y: base64.RawURLEncoding.EncodeToString(i.key.Y.FillBytes(make([]byte, 32))),
+	}}}, nil
+}
+
+func (i *testIAM) policy() auth.BearerPolicy {
+	return auth.BearerPolicy{
+		Keys:      auth.NewKeySet(i.fetch),
+		Issuer:    func(context.Context) (string, error) { return testIssuer, nil },
+		Audience:  "hello-world",
+		ClockSkew: 30 * time.Second,
+	}
+}
+
+func (i *testIAM) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	payload := map[string]any{
+		"iss": testIssuer,
+		"sub": "user-1",
+		"aud": "hello-world",
+		"azp": "rapp-client",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(payload, name)
+			continue
+		}
+		payload[name] = value
+	}
+	token, err := jwt.Sign(jwt.Header{KeyID: "key1"}, payload, i.key)
+	assert.Nil(t, err)
+	return token
+}
+
+func claimsHandler(resp http.ResponseWriter, req *http.Request) {
+	claims, ok := auth.TokenClaimsFromContext(req.Context())
+	if !ok {
+		fmt.Fprint(resp, "anonymous")
+		return
+	}
+	fmt.Fprint(resp, claims.String())
+}
+
+func serveWithToken(handler http.Handler, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestBearerRequireAcceptsValidToken(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	token := iam.token(t, nil)
+	var bearerToken string
+	handler := iam.policy().Require(auth.Requirement{}, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		bearerToken, _ = auth.BearerTokenFromContext(req.Context())
+		claimsHandler(resp, req)
+	}))
+
+	recorder := serveWithToken(handler, token)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "user-1 (rapp-client)", recorder.Body.String())
+	assert.Equal(t, token, bearerToken)
+}
+
+func TestBearerRequireRejectsMissingToken(t *testing.T) {
+	t.Parallel()
+	handler := newTestIAM(t).policy().Require(auth.Requirement{}, http.HandlerFunc(claimsHandler))
+
+	recorder := serveWithToken(handler, "")
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+}
+
+func TestBearerRequireRejectsInvalidTokens(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	handler := iam.policy().Require(auth.Requirement{}, http.HandlerFunc(claimsHandler))
+	other := newTestIAM(t)
+
+	tokens := map[string]string{
+		"malformed":     "not-a-token",
+		"other key":     other.token(t, nil),
+		"expired":       iam.token(t, map[string]any{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"no expiry":     iam.token(t, map[string]any{"exp": nil}),
+		"not yet valid": iam.token(t, map[string]any{"nbf": time.Now().Add(time.Minute).Unix()}),
+		"issuer":        iam.token(t, map[string]any{"iss": "https://iam.example.com/auth/realms/other"}),
+		"audience":      iam.token(t, map[string]any{"aud": []string{"account"}}),
+		"no audience":   iam.token(t, map[string]any{"aud": nil}),
+	}
+	for name, token := range tokens {
+		recorder := serveWithToken(handler, token)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, name)
+		assert.Equal(t, `Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"), name)
+	}
+}
+
+func TestBearerRequireAllowsClockSkew(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	handler := iam.policy().Require(auth.Requirement{}, http.HandlerFunc(claimsHandler))
+
+	recorder := serveWithToken(handler, iam.token(t, map[string]any{
+		"exp": time.Now().Add(-10 * time.Second).Unix(),
+		"nbf": time.Now().Add(10 * time.Second).Unix(),
+	}))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestBearerRequireChecksScopesAndRoles(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	handler := iam.policy().Require(auth.Requirement{Scopes: []string{"hello"}, Roles: []string{"reader", "writer"}},
+		http.HandlerFunc(claimsHandler))
+
+	recorder := serveWithToken(handler, iam.token(t, map[string]any{
+		"scope":           "openid hello",
+		"realm_access":    map[string]any{"roles": []string{"reader"}},
+		"resource_access": map[string]any{"hello-world": map[string]any{"roles": []string{"writer"}}},
+	}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = serveWithToken(handler, iam.token(t, map[string]any{
+		"scope":        "openid",
+		"realm_access": map[string]any{"roles": []string{"reader", "writer"}},
+	}))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Equal(t, `Bearer error="insufficient_scope", scope="hello"`, recorder.Header().Get("WWW-Authenticate"))
+
+	// roles of other clients do not count
+	recorder = serveWithToken(handler, iam.token(t, map[string]any{
+		"scope":           "hello",
+		"realm_access":    map[string]any{"roles": []string{"reader"}},
+		"resource_access": map[string]any{"other": map[string]any{"roles": []string{"writer"}}},
+	}))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestBearerRequireFailsWhenKeysAreUnavailable(t *testing.T) {
+	t.Parallel()
+	iam := newTestIAM(t)
+	iam.err = errors.New("connection refused")
+	handler := iam.policy().Require(auth.Requirement{}, http.HandlerFunc(claimsHandler))
+
+	recorder := serveWithToken(handler, iam.token(t, nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestBearerRequireIntrospectsOpaqueTokens(t *testing.T) {
+	t.Parallel()
+	policy := newTestIAM(t).policy()
+	var introspected []string
+	policy.Introspect = func(_ context.Context, token string) (*auth.TokenClaims, error) {
+		introspected = append(introspected, token)
+		if token != "active" {
+			return nil, auth.ErrInactive
+		}
+		claims := &auth.TokenClaims{Scope: "hello", ClientID: "rapp-client"}
+		claims.Subject = "user-1"
+		claims.Audience = jwt.Audience{"hello-world"}
+		claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+		return claims, nil
+	}
+	handler := policy.Require(auth.Requirement{Scopes: []string{"hello"}}, http.HandlerFunc(claimsHandler))
+
+	recorder := serveWithToken(handler, "active")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "user-1 (rapp-client)", recorder.Body.String())
+
+	recorder = serveWithToken(handler, "revoked")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, []string{"active", "revoked"}, introspected)
+}
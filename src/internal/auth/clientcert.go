@@ -0,0 +1,122 @@
+// Package auth provides middleware authenticating the callers of inbound requests
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	clientIdentityKey contextKey = iota
+	tokenClaimsKey
+	bearerTokenKey
+)
+
+// ClientIdentity is the identity taken from a client certificate verified against the platform CA
+type ClientIdentity struct {
+	Subject    string
+	CommonName string
+	DNSNames   []string
+	URIs       []string
+	Emails     []string
+}
+
+// String returns the subject, which is what is logged for a client
+func (c *ClientIdentity) String() string {
+	return c.Subject
+}
+
+// ClientIdentityFromContext returns the verified client identity of the request, if any
+func ClientIdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	identity, ok := ctx.Value(clientIdentityKey).(*ClientIdentity)
+	return identity, ok
+}
+
+// ClientCertPolicy decides which verified client certificates are accepted.
+// A certificate is accepted when both allowlists are empty, or when it matches an entry of either list.
+type ClientCertPolicy struct {
+	// AllowedSubjects are matched against the subject common name and the full subject
+	AllowedSubjects []string
+	// AllowedSANs are matched against the DNS, URI and email subject alternative names
+	AllowedSANs []string
+}
+
+// Require rejects requests without a verified client certificate with 401,
+// and requests whose certificate is not allowed by the policy with 403
+func (p ClientCertPolicy) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		identity := verifiedIdentity(req)
+		if identity == nil {
+			requestLog(req).Warning("Rejected request without client certificate")
+			http.Error(resp, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if !p.allows(identity) {
+			requestLog(req).WithSubject(identity.String()).Warning("Rejected client certificate")
+			http.Error(resp, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), clientIdentityKey, identity)))
+	})
+}
+
+// Identify makes a verified client certificate available to the handler without requiring one
+func (p ClientCertPolicy) Identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if identity := verifiedIdentity(req); identity != nil && p.allows(identity) {
+			req = req.WithContext(context.WithValue(req.Context(), clientIdentityKey, identity))
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+func (p ClientCertPolicy) allows(identity *ClientIdentity) bool {
+	if len(p.AllowedSubjects) == 0 && len(p.AllowedSANs) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedSubjects {
+		if allowed == identity.CommonName || allowed == identity.Subject {
+			return true
+		}
+	}
+	for _, allowed := range p.AllowedSANs {
+		if contains(identity.DNSNames, allowed) || contains(identity.URIs, allowed) || contains(identity.Emails, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifiedIdentity only trusts chains the TLS server verified, presented but unverified certificates are ignored
+func verifiedIdentity(req *http.Request) *ClientIdentity {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return newClientIdentity(req.TLS.VerifiedChains[0][0])
+}
+
+func newClientIdentity(cert *x509.Certificate) *ClientIdentity {
+	identity := &ClientIdentity{
+		Subject:    cert.Subject.String(),
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+		Emails:     cert.EmailAddresses,
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
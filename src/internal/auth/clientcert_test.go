@@ -0,0 +1,112 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"eric-oss-hello-world-go-app/src/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithClientCert(cert *x509.Certificate) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	if cert != nil {
+		request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	return request
+}
+
+func identityHandler(resp http.ResponseWriter, req *http.Request) {
+	identity, ok := auth.ClientIdentityFromContext(req.Context())
+	if !ok {
+		fmt.Fprint(resp, "anonymous")
+		return
+	}
+	fmt.Fprint(resp, identity.CommonName)
+}
+
+var clientCert = &x509.Certificate{
+	Subject:  pkix.Name{CommonName: "rapp-client", Organization: []string{"test"}},
+	DNSNames: []string{"rapp-client.default.svc"},
+	URIs:     []*url.URL{{Scheme: "spiffe", Host: "cluster.local", Path: "/ns/default/sa/rapp"}},
+}
+
+func TestRequireClientCert(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		policy   auth.ClientCertPolicy
+		cert     *x509.Certificate
+		status   int
+		response string
+	}{
+		{name: "no certificate", cert: nil, status: http.StatusUnauthorized},
+		{name: "no allowlist", cert: clientCert, status: http.StatusOK, response: "rapp-client"},
+		{
+			name: "allowed common name", cert: clientCert, status: http.StatusOK, response: "rapp-client",
+			policy: auth.ClientCertPolicy{AllowedSubjects: []string{"rapp-client"}},
+		},
+		{
+			name: "allowed full subject", cert: clientCert, status: http.StatusOK, response: "rapp-client",
+			policy: auth.ClientCertPolicy{AllowedSubjects: []string{"CN=rapp-client,O=test"}},
+		},
+		{
+			name: "allowed URI SAN", cert: clientCert, status: http.StatusOK, response: "rapp-client",
+			policy: auth.ClientCertPolicy{AllowedSANs: []string{"spiffe://cluster.local/ns/default/sa/rapp"}},
+		},
+		{
+			name: "not allowed", cert: clientCert, status: http.StatusForbidden,
+			policy: auth.ClientCertPolicy{
+				AllowedSubjects: []string{"other-client"},
+				AllowedSANs:     []string{"other-client.default.svc"},
+			},
+		},
+	}
+
+	for _, testParameters := range tests {
+		// arrange
+		response := httptest.NewRecorder()
+
+		// act
+		testParameters.policy.Require(http.HandlerFunc(identityHandler)).
+			ServeHTTP(response, requestWithClientCert(testParameters.cert))
+
+		// assert
+		assert.Equal(t, testParameters.status, response.Code, testParameters.name)
+		if testParameters.status == http.StatusOK {
+			assert.Equal(t, testParameters.response, response.Body.String(), testParameters.name)
+		}
+	}
+}
+
+func TestIdentifyClientCert(t *testing.T) {
+	t.Parallel()
+	policy := auth.ClientCertPolicy{}
+
+	response := httptest.NewRecorder()
+	policy.Identify(http.HandlerFunc(identityHandler)).ServeHTTP(response, requestWithClientCert(nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "anonymous", response.Body.String())
+
+	response = httptest.NewRecorder()
+	policy.Identify(http.HandlerFunc(identityHandler)).ServeHTTP(response, requestWithClientCert(clientCert))
+	assert.Equal(t, "rapp-client", response.Body.String())
+}
+
+func TestUnverifiedClientCertIsIgnored(t *testing.T) {
+	t.Parallel()
+	request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+	response := httptest.NewRecorder()
+
+	auth.ClientCertPolicy{}.Require(http.HandlerFunc(identityHandler)).ServeHTTP(response, request)
+
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+}
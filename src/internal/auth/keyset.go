@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+	log "eric-oss-hello-world-go-app/src/internal/logging"
+)
+
+const (
+	// keySetMaxAge after which the keys are fetched again, so removed keys stop being trusted
+	keySetMaxAge = 10 * time.Minute
+	// keySetMinRefresh limits how often tokens with an unknown key ID cause a fetch
+	keySetMinRefresh = 30 * time.Second
+)
+
+// ErrUnknownKey no signing key of the IAM matches the token
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet caches the signing keys of the IAM. The keys are fetched again when they get old, or when a
+// token names a key ID that is not known yet, which is how a key rotation is picked up.
+// Concurrent callers needing the keys share a single fetch, which runs without holding the lock.
+type KeySet struct {
+	fetch func(ctx context.Context) (*jwt.JWKS, error)
+	now   func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	// attemptedAt is the time of the last fetch and lastErr its error, a failed fetch is not
+	// retried before keySetMinRefresh either
+	attemptedAt time.Time
+	lastErr     error
+	inflight    *keyFetch
+}
+
+// keyFetch is a fetch of the keys shared by the callers waiting for it
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeySet Create a KeySet getting the keys from fetch
+func NewKeySet(fetch func(ctx context.Context) (*jwt.JWKS, error)) *KeySet {
+	return &KeySet{fetch: fetch, now: time.Now}
+}
+
+// Key returns the signing key with the given ID. A token without key ID is accepted when the IAM has a single key.
+// The cached key is used when the keys are old but cannot be fetched again.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.lookup(kid)
+	now := s.now()
+	if ok && now.Sub(s.fetchedAt) < keySetMaxAge {
+		s.mu.Unlock()
+		return key, nil
+	}
+	call := s.inflight
+	if call == nil {
+		if now.Sub(s.attemptedAt) < keySetMinRefresh {
+			err := s.lastErr
+			s.mu.Unlock()
+			return keyOrError(key, ok, kid, err)
+		}
+		call = &keyFetch{done: make(chan struct{})}
+		s.inflight = call
+		// the fetch is shared, so a caller giving up does not cancel it for the others
+		go s.runFetch(context.WithoutCancel(ctx), call)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		if ok {
+			return key, nil
+		}
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	key, ok = s.lookup(kid)
+	s.mu.Unlock()
+	return keyOrError(key, ok, kid, call.err)
+}
+
+// keyOrError returns the key when it was found, otherwise the error of the last fetch or ErrUnknownKey
+func keyOrError(key crypto.PublicKey, ok bool, kid string, err error) (crypto.PublicKey, error) {
+	if ok {
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// lookup finds a cached key, the caller holds s.mu
+func (s *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// runFetch fetches the keys for call and caches them, the time of a failed fetch is recorded as well
+// so the IAM is not asked again by every request while it is failing
+func (s *KeySet) runFetch(ctx context.Context, call *keyFetch) {
+	keys, err := s.fetchKeys(ctx)
+
+	s.mu.Lock()
+	s.attemptedAt = s.now()
+	s.lastErr = err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = s.attemptedAt
+	}
+	cached := len(s.keys) > 0
+	call.err = err
+	s.inflight = nil
+	s.mu.Unlock()
+	close(call.done)
+
+	if err != nil && cached {
+		log.WithError(err).Warning("Could not refresh the IAM signing keys, using the cached ones")
+	}
+}
+
+// fetchKeys gets the signing keys of the IAM, the keys that cannot be used to verify signatures are left out
+func (s *KeySet) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwks, err := s.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the IAM signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.WithField("kid", jwk.KeyID).WithError(err).Debug("Ignoring IAM key")
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	return keys, nil
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ecJWK(t *testing.T, kid string) jwt.JWK {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return jwt.JWK{
+		KeyType: "EC", KeyID: kid, Use: "sig", Curve: "P-256",
+		X: base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y: base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// fakeKeys serves jwks and counts the fetches
+type fakeKeys struct {
+	jwks    jwt.JWKS
+	err     error
+	fetches int
+}
+
+func (f *fakeKeys) fetch(context.Context) (*jwt.JWKS, error) {
+	f.fetches++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &jwt.JWKS{Keys: f.jwks.Keys}, nil
+}
+
+func newTestKeySet(keys *fakeKeys) (*KeySet, *time.Time) {
+	now := time.Unix(1000, 0)
+	set := NewKeySet(keys.fetch)
+	set.now = func() time.Time { return now }
+	return set, &now
+}
+
+func TestKeySetCachesKeys(t *testing.T) {
+	t.Parallel()
+	keys := &fakeKeys{jwks: jwt.JWKS{Keys: []jwt.JWK{ecJWK(t, "key1"), ecJWK(t, "key2")}}}
+	set, _ := newTestKeySet(keys)
+
+	key1, err := set.Key(context.Background(), "key1")
+	assert.Nil(t, err)
+	assert.NotNil(t, key1)
+	_, err = set.Key(context.Background(), "key2")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, keys.fetches)
+
+	// several keys, so a token must name one
+	_, err = set.Key(context.Background(), "")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeySetPicksUpRotatedKey(t *testing.T) {
+	t.Parallel()
+	keys := &fakeKeys{jwks: jwt.JWKS{Keys: []jwt.JWK{ecJWK(t, "old")}}}
+	set, now := newTestKeySet(keys)
+
+	_, err := set.Key(context.Background(), "old")
+	assert.Nil(t, err)
+
+	keys.jwks.Keys = []jwt.JWK{ecJWK(t, "new")}
+	// unknown key IDs do not cause a fetch right after the last one
+	_, err = set.Key(context.Background(), "new")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, keys.fetches)
+
+	*now = now.Add(keySetMinRefresh)
+	_, err = set.Key(context.Background(), "new")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys.fetches)
+	_, err = set.Key(context.Background(), "old")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestKeySetRefreshesOldKeys(t *testing.T) {
+	t.Parallel()
+	keys := &fakeKeys{jwks: jwt.JWKS{Keys: []jwt.JWK{ecJWK(t, "")}}}
+	set, now := newTestKeySet(keys)
+
+	_, err := set.Key(context.Background(), "")
+	assert.Nil(t, err)
+
+	// the cached key is still used when the IAM cannot be reached
+	keys.err = errors.New("connection refused")
+	*now = now.Add(keySetMaxAge)
+	_, err = set.Key(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, keys.fetches)
+
+	// a failed fetch backs off like a successful one, its error is returned meanwhile
+	_, err = set.Key(context.Background(), "other")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.NotErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 2, keys.fetches)
+
+	*now = now.Add(keySetMinRefresh)
+	_, err = set.Key(context.Background(), "other")
+	assert.ErrorContains(t, err, "connection refused")
+	assert.Equal(t, 3, keys.fetches)
+}
+
+func TestKeySetSharesOneFetch(t *testing.T) {
+	t.Parallel()
+	jwk := ecJWK(t, "key1")
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	set := NewKeySet(func(context.Context) (*jwt.JWKS, error) {
+		fetches.Add(1)
+		<-release
+		return &jwt.JWKS{Keys: []jwt.JWK{jwk}}, nil
+	})
+
+	// a caller giving up does not hold the others back nor cancel the fetch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := set.Key(ctx, "key1")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := set.Key(context.Background(), "key1")
+			assert.Nil(t, err)
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestKeySetIgnoresUnusableKeys(t *testing.T) {
+	t.Parallel()
+	encryption := ecJWK(t, "enc")
+	encryption.Use = "enc"
+	keys := &fakeKeys{jwks: jwt.JWKS{Keys: []jwt.JWK{encryption, {KeyType: "OKP", KeyID: "okp"}, ecJWK(t, "sig")}}}
+	set, _ := newTestKeySet(keys)
+
+	_, err := set.Key(context.Background(), "sig")
+	assert.Nil(t, err)
+	assert.Len(t, set.keys, 1)
+}
@@ -0,0 +1,78 @@
+package configuration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// CertReloader serves a certificate and key pair read from disk, which can be reloaded while the server runs
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// errNoCertificate no valid key pair has been loaded yet
+var errNoCertificate = errors.New("no valid server certificate loaded")
+
+// NewCertReloader Create a CertReloader and load the initial key pair. The reloader is returned along
+// with the error when the key pair cannot be loaded, it serves no certificate until a Reload succeeds.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	return reloader, reloader.Reload()
+}
+
+// Reload reads the key pair again and switches to it only if it is valid,
+// otherwise the previous certificate keeps being served
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading key pair %s, %s failed: %w", r.certFile, r.keyFile, err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing certificate %s failed: %w", r.certFile, err)
+	}
+	if err := checkValidity(r.certFile, leaf, time.Now()); err != nil {
+		return err
+	}
+
+	cert.Leaf = leaf
+	r.cert.Store(&cert)
+	return nil
+}
+
+// Certificate returns the certificate currently being served, nil when none could be loaded
+func (r *CertReloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// Check returns why no certificate valid at now is being served, for the readiness check
+func (r *CertReloader) Check(now time.Time) error {
+	cert := r.cert.Load()
+	if cert == nil {
+		return errNoCertificate
+	}
+	return checkValidity(r.certFile, cert.Leaf, now)
+}
+
+// GetCertificate can be used as the tls.Config hook so every handshake uses the current certificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := r.cert.Load()
+	if cert == nil {
+		return nil, errNoCertificate
+	}
+	return cert, nil
+}
+
+func checkValidity(certFile string, leaf *x509.Certificate, now time.Time) error {
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return errors.New("certificate " + certFile + " is not valid at the current time")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package configuration
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKeyPair writes a self-signed certificate for commonName and its key into dir
+func writeKeyPair(t *testing.T, dir, commonName string, notAfter time.Time) (certFile, keyFile string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	assert.Nil(t, err)
+	privBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0o600)
+	assert.Nil(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0o600)
+	assert.Nil(t, err)
+
+	return certFile, keyFile
+}
+
+func TestNewCertReloaderWithMissingFiles(t *testing.T) {
+	t.Parallel()
+	reloader, err := NewCertReloader("missing.crt", "missing.key")
+
+	assert.Contains(t, err.Error(), "loading key pair")
+	assert.Nil(t, reloader.Certificate())
+	assert.ErrorIs(t, reloader.Check(time.Now()), errNoCertificate)
+	_, err = reloader.GetCertificate(nil)
+	assert.ErrorIs(t, err, errNoCertificate)
+}
+
+func TestCertReloaderReportsExpiredCertificate(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "expired.test", time.Now().Add(-time.Minute))
+	reloader, err := NewCertReloader(certFile, keyFile)
+	assert.ErrorContains(t, err, "is not valid at the current time")
+	assert.ErrorIs(t, reloader.Check(time.Now()), errNoCertificate)
+
+	writeKeyPair(t, dir, "valid.test", time.Now().Add(time.Hour))
+	assert.Nil(t, reloader.Reload())
+	assert.Nil(t, reloader.Check(time.Now()))
+	assert.ErrorContains(t, reloader.Check(time.Now().Add(2*time.Hour)), "is not valid at the current time",
+		"The served certificate should be reported once it expires")
+}
+
+func TestCertReloaderServesRotatedCertificate(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "old.test", time.Now().Add(time.Hour))
+	reloader, err := NewCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	cert, err := reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "old.test", cert.Leaf.Subject.CommonName)
+
+	writeKeyPair(t, dir, "new.test", time.Now().Add(time.Hour))
+	assert.Nil(t, reloader.Reload())
+
+	cert, err = reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "new.test", cert.Leaf.Subject.CommonName)
+}
+
+func TestCertReloaderKeepsCertificateWhenRotationIsBroken(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "old.test", time.Now().Add(time.Hour))
+	reloader, err := NewCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	// a key that does not match the certificate, as seen mid-way through a non-atomic update
+	otherDir := t.TempDir()
+	_, otherKey := writeKeyPair(t, otherDir, "other.test", time.Now().Add(time.Hour))
+	data, err := os.ReadFile(otherKey)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(keyFile, data, 0o600))
+
+	err = reloader.Reload()
+	assert.NotNil(t, err)
+	assert.Equal(t, "old.test", reloader.Certificate().Leaf.Subject.CommonName)
+
+	// an expired certificate
+	writeKeyPair(t, dir, "expired.test", time.Now().Add(-time.Minute))
+	err = reloader.Reload()
+	assert.Contains(t, err.Error(), "is not valid at the current time")
+	assert.Equal(t, "old.test", reloader.Certificate().Leaf.Subject.CommonName)
+}
@@ -4,59 +4,334 @@ package configuration
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Config is a struct that contains all fields currently read from OS environment variables
 type Config struct {
-	LocalPort       int
-	LocalProtocol   string
-	CertFile        string
-	KeyFile         string
-	ContainerName   string
-	IamClientID     string
-	IamClientSecret string
-	IamBaseURL      string
-	CaCertFileName  string
-	CaCertFilePath  string
-	LogControlFile  string
-	LogEndpoint     string
-	AppKey          string
-	AppCert         string
-	AppCertFilePath string
-}
-
-const localPort = 8050
-
-// AppConfig contains a list of values read from OS environment variables
-var AppConfig = configFromEnvVars()
+	LocalPort                 int
+	LocalProtocol             string
+	CertFile                  string
+	KeyFile                   string
+	ContainerName             string
+	PodName                   string
+	PodUID                    string
+	Namespace                 string
+	NodeName                  string
+	AppVersion                string
+	IamClientID               string
+	IamClientSecret           string
+	IamBaseURL                string
+	IamRealm                  string
+	IamTenant                 string
+	IamScopes                 []string
+	IamAudience               string
+	IamTokenPath              string
+	IamOIDCDiscovery          bool
+	IamClientAuthMethod       string
+	CaCertFileName            string
+	CaCertFilePath            string
+	LogControlFile            string
+	LogEndpoint               string
+	LogServiceID              string
+	LogQueueSize              int
+	LogBatchSize              int
+	LogBatchInterval          time.Duration
+	LogDropPolicy             string
+	LogSpoolDir               string
+	LogSpoolMaxSize           int
+	AppKey                    string
+	AppCert                   string
+	AppCertFilePath           string
+	ShutdownDelay             time.Duration
+	ShutdownTimeout           time.Duration
+	CertWatchInterval         time.Duration
+	LogControlWatchInterval   time.Duration
+	ClientCertRoutes          []string
+	ClientCertAllowedSubjects []string
+	ClientCertAllowedSANs     []string
+	HTTPDialTimeout           time.Duration
+	HTTPTLSHandshakeTimeout   time.Duration
+	HTTPResponseHeaderTimeout time.Duration
+	HTTPClientTimeout         time.Duration
+	HTTPIdleConnTimeout       time.Duration
+	HTTPMaxIdleConnsPerHost   int
+	HTTPMaxResponseSize       int
+	IamMaxResponseSize        int
+	IamRetryMaxAttempts       int
+	IamRetryInitialBackoff    time.Duration
+	IamRetryMaxBackoff        time.Duration
+	IamBreakerThreshold       int
+	IamBreakerOpenTimeout     time.Duration
+	IamIntrospectionCacheTTL  time.Duration
+	RequestTimeout            time.Duration
+	JWTRoutes                 []string
+	JWTAudience               string
+	JWTClockSkew              time.Duration
+	JWTIntrospection          bool
+}
+
+const (
+	localPort         = 8050
+	logServiceID      = "rapp-eric-oss-hello-world-go-app"
+	iamRealm          = "master"
+	shutdownTimeout   = 25 * time.Second
+	certWatchInterval = 10 * time.Second
+	logControlWatch   = 10 * time.Second
+
+	httpDialTimeout           = 5 * time.Second
+	httpTLSHandshakeTimeout   = 5 * time.Second
+	httpResponseHeaderTimeout = 10 * time.Second
+	httpClientTimeout         = 30 * time.Second
+	httpIdleConnTimeout       = 90 * time.Second
+	httpMaxIdleConnsPerHost   = 10
+	httpMaxResponseSize       = 4 << 20
+	iamMaxResponseSize        = 1 << 20
+
+	iamRetryMaxAttempts    = 3
+	iamRetryInitialBackoff = 200 * time.Millisecond
+	iamRetryMaxBackoff     = 5 * time.Second
+	iamBreakerThreshold    = 5
+	iamBreakerOpenTimeout  = 30 * time.Second
+
+	iamIntrospectionCacheTTL = 30 * time.Second
+
+	logQueueSize     = 1000
+	logBatchSize     = 100
+	logBatchInterval = time.Second
+	logSpoolMaxSize  = 16 << 20
+
+	requestTimeout = 10 * time.Second
+	jwtClockSkew   = 30 * time.Second
+)
+
+// Client authentication methods at the IAM token endpoint, named as in the OAuth 2.0 registry
+const (
+	// ClientSecretPost sends the client secret in the form body
+	ClientSecretPost = "client_secret_post"
+	// ClientSecretBasic sends the client secret with HTTP Basic authentication
+	ClientSecretBasic = "client_secret_basic"
+	// PrivateKeyJWT sends a client assertion signed with the app key (RFC 7523)
+	PrivateKeyJWT = "private_key_jwt"
+	// TLSClientAuth authenticates with the app certificate over mTLS (RFC 8705)
+	TLSClientAuth = "tls_client_auth"
+)
+
+// Policies applied by the remote log shipper when its queue is full
+const (
+	// LogDropOldest drops the oldest queued entry to make room for the new one
+	LogDropOldest = "drop-oldest"
+	// LogDropNewest drops the new entry
+	LogDropNewest = "drop-newest"
+	// LogBlock blocks the caller until there is room in the queue
+	LogBlock = "block"
+)
+
+// appConfig contains a list of values read from OS environment variables, a reload replaces it as a whole
+var appConfig atomic.Pointer[Config]
+
+func init() {
+	appConfig.Store(configFromEnvVars())
+}
+
+// Current Returns the configuration read from OS environment variables. Readers call it once per use,
+// so the values they work with all come from the same configuration while a reload replaces it.
+func Current() *Config {
+	return appConfig.Load()
+}
 
 // ReloadAppConfig can be used to force a re-read of OS environment variables
 func ReloadAppConfig() {
-	AppConfig = configFromEnvVars()
+	appConfig.Store(configFromEnvVars())
+}
+
+// ReadAppConfig Returns a configuration read from OS environment variables, without making it the current one,
+// so it can be validated first
+func ReadAppConfig() *Config {
+	return configFromEnvVars()
+}
+
+// SetAppConfig replaces the current configuration by config, once a reload validated it or to restore it after a test
+func SetAppConfig(config *Config) {
+	appConfig.Store(config)
 }
 
 func configFromEnvVars() *Config {
 	return &Config{
-		LocalPort:       getOsEnvInt("LOCAL_PORT", localPort),
-		LocalProtocol:   getOsEnvString("LOCAL_PROTOCOL", "http"),
-		CertFile:        getOsEnvString("CERT_FILE", "certificate.pem"),
-		KeyFile:         getOsEnvString("KEY_FILE", "key.pem"),
-		ContainerName:   getOsEnvString("CONTAINER_NAME", ""),
-		IamClientID:     getOsEnvString("IAM_CLIENT_ID", ""),
-		IamClientSecret: getOsEnvString("IAM_CLIENT_SECRET", ""),
-		IamBaseURL:      getOsEnvString("IAM_BASE_URL", ""),
-		CaCertFileName:  getOsEnvString("CA_CERT_FILE_NAME", ""),
-		CaCertFilePath:  getOsEnvString("CA_CERT_FILE_PATH", ""),
-		LogControlFile:  getOsEnvString("LOG_CTRL_FILE", ""),
-		LogEndpoint:     getOsEnvString("LOG_ENDPOINT", ""),
-		AppKey:          getOsEnvString("APP_KEY", ""),
-		AppCert:         getOsEnvString("APP_CERT", ""),
-		AppCertFilePath: getOsEnvString("APP_CERT_FILE_PATH", ""),
+		LocalPort:                 getOsEnvInt("LOCAL_PORT", localPort),
+		LocalProtocol:             getOsEnvString("LOCAL_PROTOCOL", "http"),
+		CertFile:                  getOsEnvString("CERT_FILE", "certificate.pem"),
+		KeyFile:                   getOsEnvString("KEY_FILE", "key.pem"),
+		ContainerName:             getOsEnvString("CONTAINER_NAME", ""),
+		PodName:                   getOsEnvString("POD_NAME", ""),
+		PodUID:                    getOsEnvString("POD_UID", ""),
+		Namespace:                 getOsEnvString("NAMESPACE", ""),
+		NodeName:                  getOsEnvString("NODE_NAME", ""),
+		AppVersion:                getOsEnvString("APP_VERSION", BuildVersion()),
+		IamClientID:               getOsEnvString("IAM_CLIENT_ID", ""),
+		IamClientSecret:           getOsEnvString("IAM_CLIENT_SECRET", ""),
+		IamBaseURL:                getOsEnvString("IAM_BASE_URL", ""),
+		IamRealm:                  getOsEnvString("IAM_REALM", iamRealm),
+		IamTenant:                 getOsEnvString("IAM_TENANT", iamRealm),
+		IamScopes:                 getOsEnvList("IAM_SCOPES"),
+		IamAudience:               getOsEnvString("IAM_AUDIENCE", ""),
+		IamTokenPath:              getOsEnvString("IAM_TOKEN_PATH", ""),
+		IamOIDCDiscovery:          getOsEnvBool("IAM_OIDC_DISCOVERY", false),
+		IamClientAuthMethod:       getOsEnvString("IAM_CLIENT_AUTH_METHOD", ClientSecretPost),
+		CaCertFileName:            getOsEnvString("CA_CERT_FILE_NAME", ""),
+		CaCertFilePath:            getOsEnvString("CA_CERT_FILE_PATH", ""),
+		LogControlFile:            getOsEnvString("LOG_CTRL_FILE", ""),
+		LogEndpoint:               getOsEnvString("LOG_ENDPOINT", ""),
+		LogServiceID:              getOsEnvString("LOG_SERVICE_ID", logServiceID),
+		LogQueueSize:              getOsEnvInt("LOG_QUEUE_SIZE", logQueueSize),
+		LogBatchSize:              getOsEnvInt("LOG_BATCH_SIZE", logBatchSize),
+		LogBatchInterval:          getOsEnvDuration("LOG_BATCH_INTERVAL", logBatchInterval),
+		LogDropPolicy:             getOsEnvString("LOG_DROP_POLICY", LogDropOldest),
+		LogSpoolDir:               getOsEnvString("LOG_SPOOL_DIR", ""),
+		LogSpoolMaxSize:           getOsEnvInt("LOG_SPOOL_MAX_SIZE", logSpoolMaxSize),
+		AppKey:                    getOsEnvString("APP_KEY", ""),
+		AppCert:                   getOsEnvString("APP_CERT", ""),
+		AppCertFilePath:           getOsEnvString("APP_CERT_FILE_PATH", ""),
+		ShutdownDelay:             getOsEnvDuration("SHUTDOWN_DELAY", 0),
+		ShutdownTimeout:           getOsEnvDuration("SHUTDOWN_TIMEOUT", shutdownTimeout),
+		CertWatchInterval:         getOsEnvDuration("CERT_WATCH_INTERVAL", certWatchInterval),
+		LogControlWatchInterval:   getOsEnvDuration("LOG_CTRL_WATCH_INTERVAL", logControlWatch),
+		ClientCertRoutes:          getOsEnvList("CLIENT_CERT_ROUTES"),
+		ClientCertAllowedSubjects: getOsEnvList("CLIENT_CERT_ALLOWED_SUBJECTS"),
+		ClientCertAllowedSANs:     getOsEnvList("CLIENT_CERT_ALLOWED_SANS"),
+		HTTPDialTimeout:           getOsEnvDuration("HTTP_DIAL_TIMEOUT", httpDialTimeout),
+		HTTPTLSHandshakeTimeout:   getOsEnvDuration("HTTP_TLS_HANDSHAKE_TIMEOUT", httpTLSHandshakeTimeout),
+		HTTPResponseHeaderTimeout: getOsEnvDuration("HTTP_RESPONSE_HEADER_TIMEOUT", httpResponseHeaderTimeout),
+		HTTPClientTimeout:         getOsEnvDuration("HTTP_CLIENT_TIMEOUT", httpClientTimeout),
+		HTTPIdleConnTimeout:       getOsEnvDuration("HTTP_IDLE_CONN_TIMEOUT", httpIdleConnTimeout),
+		HTTPMaxIdleConnsPerHost:   getOsEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", httpMaxIdleConnsPerHost),
+		HTTPMaxResponseSize:       getOsEnvInt("HTTP_MAX_RESPONSE_SIZE", httpMaxResponseSize),
+		IamMaxResponseSize:        getOsEnvInt("IAM_MAX_RESPONSE_SIZE", iamMaxResponseSize),
+		IamRetryMaxAttempts:       getOsEnvInt("IAM_RETRY_MAX_ATTEMPTS", iamRetryMaxAttempts),
+		IamRetryInitialBackoff:    getOsEnvDuration("IAM_RETRY_INITIAL_BACKOFF", iamRetryInitialBackoff),
+		IamRetryMaxBackoff:        getOsEnvDuration("IAM_RETRY_MAX_BACKOFF", iamRetryMaxBackoff),
+		IamBreakerThreshold:       getOsEnvInt("IAM_BREAKER_THRESHOLD", iamBreakerThreshold),
+		IamBreakerOpenTimeout:     getOsEnvDuration("IAM_BREAKER_OPEN_TIMEOUT", iamBreakerOpenTimeout),
+		IamIntrospectionCacheTTL:  getOsEnvDuration("IAM_INTROSPECTION_CACHE_TTL", iamIntrospectionCacheTTL),
+		RequestTimeout:            getOsEnvDuration("REQUEST_TIMEOUT", requestTimeout),
+		JWTRoutes:                 getOsEnvList("JWT_ROUTES"),
+		JWTAudience:               getOsEnvString("JWT_AUDIENCE", ""),
+		JWTClockSkew:              getOsEnvDuration("JWT_CLOCK_SKEW", jwtClockSkew),
+		JWTIntrospection:          getOsEnvBool("JWT_INTROSPECTION", false),
+	}
+}
+
+// Validate reports the settings that would stop the app from working, joined into one error
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.LocalProtocol != "http" && c.LocalProtocol != "https" {
+		errs = append(errs, errors.New("LOCAL_PROTOCOL must be http or https"))
+	}
+	if c.LocalProtocol == "https" && (c.CertFile == "" || c.KeyFile == "") {
+		errs = append(errs, errors.New("CERT_FILE and KEY_FILE are required when LOCAL_PROTOCOL is https"))
+	}
+	switch c.IamClientAuthMethod {
+	case "", ClientSecretPost, ClientSecretBasic:
+		if c.IamClientID == "" || c.IamClientSecret == "" {
+			errs = append(errs, errors.New("IAM_CLIENT_ID and IAM_CLIENT_SECRET are required"))
+		}
+	case PrivateKeyJWT, TLSClientAuth:
+		if c.IamClientID == "" {
+			errs = append(errs, errors.New("IAM_CLIENT_ID is required"))
+		}
+		if c.AppCert == "" || c.AppKey == "" {
+			errs = append(errs, errors.New("APP_CERT and APP_KEY are required when IAM_CLIENT_AUTH_METHOD is "+
+				c.IamClientAuthMethod))
+		}
+	default:
+		errs = append(errs, errors.New("IAM_CLIENT_AUTH_METHOD must be one of "+ClientSecretPost+", "+
+			ClientSecretBasic+", "+PrivateKeyJWT+" or "+TLSClientAuth))
+	}
+	if u, err := url.Parse(c.IamBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.New("IAM_BASE_URL must be an absolute URL"))
+	}
+	if c.IamTokenPath != "" && !strings.HasPrefix(c.IamTokenPath, "/") {
+		errs = append(errs, errors.New("IAM_TOKEN_PATH must start with /"))
+	}
+	if len(c.ClientCertRoutes) > 0 && c.LocalProtocol != "https" {
+		errs = append(errs, errors.New("CLIENT_CERT_ROUTES requires LOCAL_PROTOCOL https"))
+	}
+	if _, err := c.JWTRouteRequirements(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(c.JWTRoutes) > 0 && c.JWTAudience == "" {
+		// without an audience any token of the realm would be accepted, whichever client it was issued for
+		errs = append(errs, errors.New("JWT_AUDIENCE is required when JWT_ROUTES is set"))
+	}
+	if c.LogEndpoint != "" && (c.AppCert == "" || c.AppKey == "") {
+		errs = append(errs, errors.New("APP_CERT and APP_KEY are required when LOG_ENDPOINT is set"))
+	}
+	switch c.LogDropPolicy {
+	case "", LogDropOldest, LogDropNewest, LogBlock:
+	default:
+		errs = append(errs, errors.New("LOG_DROP_POLICY must be one of "+LogDropOldest+", "+LogDropNewest+" or "+LogBlock))
+	}
+
+	return errors.Join(errs...)
+}
+
+// RealmPath Returns the path of the IAM realm relative to IAM_BASE_URL
+func (c *Config) RealmPath() string {
+	realm := c.IamRealm
+	if realm == "" {
+		realm = iamRealm
+	}
+	return "/auth/realms/" + url.PathEscape(realm)
+}
+
+// TokenPath Returns the path of the token endpoint relative to IAM_BASE_URL, IAM_TOKEN_PATH when it is set
+func (c *Config) TokenPath() string {
+	if c.IamTokenPath != "" {
+		return c.IamTokenPath
+	}
+	return c.RealmPath() + "/protocol/openid-connect/token"
+}
+
+// JWTRoute is an entry of JWT_ROUTES, a route pattern followed by the scopes and roles a bearer token needs
+// for it, e.g. "/hello=scope:hello role:reader"
+type JWTRoute struct {
+	Pattern string
+	Scopes  []string
+	Roles   []string
+}
+
+// JWTRouteRequirements parses JWT_ROUTES
+func (c *Config) JWTRouteRequirements() ([]JWTRoute, error) {
+	routes := make([]JWTRoute, 0, len(c.JWTRoutes))
+	for _, spec := range c.JWTRoutes {
+		pattern, requirements, _ := strings.Cut(spec, "=")
+		route := JWTRoute{Pattern: strings.TrimSpace(pattern)}
+		if !strings.HasPrefix(route.Pattern, "/") {
+			return nil, errors.New("JWT_ROUTES entry " + strconv.Quote(spec) + " must start with a path")
+		}
+		for _, requirement := range strings.Fields(requirements) {
+			kind, value, _ := strings.Cut(requirement, ":")
+			switch {
+			case kind == "scope" && value != "":
+				route.Scopes = append(route.Scopes, value)
+			case kind == "role" && value != "":
+				route.Roles = append(route.Roles, value)
+			default:
+				return nil, errors.New("JWT_ROUTES requirement " + strconv.Quote(requirement) +
+					" must be scope:<name> or role:<name>")
+			}
+		}
+		routes = append(routes, route)
 	}
+	return routes, nil
 }
 
 func getOsEnvInt(envName string, defaultValue int) int {
@@ -69,6 +344,38 @@ func getOsEnvInt(envName string, defaultValue int) int {
 	return result
 }
 
+func getOsEnvBool(envName string, defaultValue bool) bool {
+	envValue := strings.TrimSpace(os.Getenv(envName))
+	result, err := strconv.ParseBool(envValue)
+	if err != nil {
+		result = defaultValue
+	}
+
+	return result
+}
+
+func getOsEnvDuration(envName string, defaultValue time.Duration) time.Duration {
+	envValue := strings.TrimSpace(os.Getenv(envName))
+	result, err := time.ParseDuration(envValue)
+	if err != nil || result < 0 {
+		result = defaultValue
+	}
+
+	return result
+}
+
+// getOsEnvList splits a comma separated variable, ignoring empty items
+func getOsEnvList(envName string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(envName), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
 func getOsEnvString(envName, defaultValue string) string {
 	result := strings.TrimSpace(os.Getenv(envName))
 
@@ -79,18 +386,28 @@ func getOsEnvString(envName, defaultValue string) string {
 	return result
 }
 
-// NewTLSConfig Create new TLS configuration
-func NewTLSConfig() *tls.Config {
+// CACertPool Load the platform CA certificates from CA_CERT_FILE_PATH and CA_CERT_FILE_NAME
+func CACertPool() (*x509.CertPool, error) {
 	// Load the root CA certificate
 	platformCaCert, err := os.ReadFile(getCertPath())
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	// Create a new CertPool and add the root CA certificate to it
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(platformCaCert)
 
+	return caCertPool, nil
+}
+
+// NewTLSConfig Create new TLS configuration
+func NewTLSConfig() *tls.Config {
+	caCertPool, err := CACertPool()
+	if err != nil {
+		return nil
+	}
+
 	// Create a TLS config with the client certificates and the server's CA cert
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: false,
@@ -102,22 +419,32 @@ func NewTLSConfig() *tls.Config {
 	return tlsConfig
 }
 
+// AppKeyPair Load the app certificate and key from APP_CERT_FILE_PATH, APP_CERT and APP_KEY
+func AppKeyPair() (tls.Certificate, error) {
+	config := Current()
+	certFilePath := path.Join(config.AppCertFilePath, config.AppCert)
+	keyFilePath := path.Join(config.AppCertFilePath, config.AppKey)
+
+	return tls.LoadX509KeyPair(certFilePath, keyFilePath)
+}
+
+// IamTLSConfig Create the TLS configuration for the IAM, presenting the app certificate
+// when IAM_CLIENT_AUTH_METHOD is tls_client_auth
+func IamTLSConfig() *tls.Config {
+	if Current().IamClientAuthMethod == TLSClientAuth {
+		return LogmTLSConfig()
+	}
+	return NewTLSConfig()
+}
+
 // LogmTLSConfig Create new mTLS configuration for logging
 func LogmTLSConfig() *tls.Config {
-	// Load the root CA certificate
-	platformCaCert, err := os.ReadFile(getCertPath())
+	caCertPool, err := CACertPool()
 	if err != nil {
 		return nil
 	}
 
-	// Create a new CertPool and add the root CA certificate to it
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(platformCaCert)
-
-	certFilePath := path.Join(AppConfig.AppCertFilePath, AppConfig.AppCert)
-	keyFilePath := path.Join(AppConfig.AppCertFilePath, AppConfig.AppKey)
-
-	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	cert, err := AppKeyPair()
 	if err != nil {
 		return nil
 	}
@@ -134,7 +461,7 @@ func LogmTLSConfig() *tls.Config {
 
 // combines CaMountPath and CaCertFileName as a full path
 func getCertPath() (certFilePath string) {
-	config := AppConfig
+	config := Current()
 	certFilePath = path.Join(config.CaCertFilePath, config.CaCertFileName)
 	return certFilePath
 }
@@ -25,7 +25,7 @@ const (
 func TestGetConfig(t *testing.T) {
 	t.Parallel()
 
-	testConfig := AppConfig
+	testConfig := Current()
 
 	assert.NotNil(t, testConfig,
 		"Instance should not be nil")
@@ -44,15 +44,117 @@ func TestGetConfig(t *testing.T) {
 
 	assert.Equal(t, "", testConfig.LogControlFile,
 		"LogControlFile should be an empty string, but got : "+testConfig.LogControlFile)
+
+	assert.Equal(t, 25*time.Second, testConfig.ShutdownTimeout,
+		"ShutdownTimeout should be 25s, but got : "+testConfig.ShutdownTimeout.String())
+
+	assert.Equal(t, "rapp-eric-oss-hello-world-go-app", testConfig.LogServiceID,
+		"LogServiceID should be `rapp-eric-oss-hello-world-go-app`, but got : "+testConfig.LogServiceID)
+
+	assert.Equal(t, BuildVersion(), testConfig.AppVersion,
+		"AppVersion should be the build version, but got : "+testConfig.AppVersion)
+}
+
+func TestBuildVersion(t *testing.T) {
+	original := version
+	t.Cleanup(func() { version = original })
+
+	version = ""
+	assert.Equal(t, "unknown", BuildVersion(), "A test binary has no module version")
+
+	version = "4.1.0"
+	assert.Equal(t, "4.1.0", BuildVersion())
 }
 
 func TestReloadAppConfig(t *testing.T) {
 	t.Parallel()
-	config1 := AppConfig
+	config1 := Current()
 	ReloadAppConfig()
-	config2 := AppConfig
+	config2 := Current()
 	assert.NotSame(t, config1, config2,
-		"AppConfig should be different pointer after ReloadAppConfig()")
+		"Current() should be a different pointer after ReloadAppConfig()")
+}
+
+func TestLogServiceID(t *testing.T) {
+	// the chart sets SERVICE_NAME to its own name, which is not the service_id of the log entries
+	t.Setenv("SERVICE_NAME", "eric-oss-hello-world-go-app")
+	assert.Equal(t, "rapp-eric-oss-hello-world-go-app", configFromEnvVars().LogServiceID)
+
+	t.Setenv("LOG_SERVICE_ID", "rapp-hello")
+	assert.Equal(t, "rapp-hello", configFromEnvVars().LogServiceID)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := Config{
+		LocalProtocol:   "http",
+		IamClientID:     "id",
+		IamClientSecret: "secret",
+		IamBaseURL:      "https://iam.test",
+	}
+	assert.Nil(t, valid.Validate())
+
+	invalid := valid
+	invalid.LocalProtocol = "ftp"
+	invalid.IamBaseURL = "iam.test"
+	invalid.LogEndpoint = "log.test:9443"
+	invalid.IamTokenPath = "token"
+	err := invalid.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "LOCAL_PROTOCOL")
+	assert.Contains(t, err.Error(), "IAM_BASE_URL")
+	assert.Contains(t, err.Error(), "APP_CERT")
+	assert.Contains(t, err.Error(), "IAM_TOKEN_PATH")
+
+	privateKeyJWT := valid
+	privateKeyJWT.IamClientSecret = ""
+	privateKeyJWT.IamClientAuthMethod = PrivateKeyJWT
+	err = privateKeyJWT.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "APP_CERT and APP_KEY are required when IAM_CLIENT_AUTH_METHOD is private_key_jwt")
+	privateKeyJWT.AppCert, privateKeyJWT.AppKey = "app.crt", "app.key"
+	assert.Nil(t, privateKeyJWT.Validate(), "No client secret should be needed with private_key_jwt")
+
+	unknown := valid
+	unknown.IamClientAuthMethod = "none"
+	assert.ErrorContains(t, unknown.Validate(), "IAM_CLIENT_AUTH_METHOD")
+
+	dropPolicy := valid
+	dropPolicy.LogDropPolicy = "drop-all"
+	assert.ErrorContains(t, dropPolicy.Validate(), "LOG_DROP_POLICY")
+
+	jwtRoutes := valid
+	jwtRoutes.JWTRoutes = []string{"/hello=role:reader"}
+	assert.ErrorContains(t, jwtRoutes.Validate(), "JWT_AUDIENCE is required when JWT_ROUTES is set")
+	jwtRoutes.JWTAudience = "hello-world"
+	assert.Nil(t, jwtRoutes.Validate())
+}
+
+func TestTokenPath(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "/auth/realms/master/protocol/openid-connect/token", (&Config{}).TokenPath())
+	assert.Equal(t, "/auth/realms/apps/protocol/openid-connect/token", (&Config{IamRealm: "apps"}).TokenPath())
+	assert.Equal(t, "/oauth2/token", (&Config{IamRealm: "apps", IamTokenPath: "/oauth2/token"}).TokenPath())
+	assert.Equal(t, "/auth/realms/a%2Fb", (&Config{IamRealm: "a/b"}).RealmPath())
+}
+
+func TestJWTRouteRequirements(t *testing.T) {
+	t.Parallel()
+
+	routes, err := (&Config{JWTRoutes: []string{"/hello=scope:hello role:reader role:admin", "/metrics"}}).
+		JWTRouteRequirements()
+	assert.NoError(t, err)
+	assert.Equal(t, []JWTRoute{
+		{Pattern: "/hello", Scopes: []string{"hello"}, Roles: []string{"reader", "admin"}},
+		{Pattern: "/metrics"},
+	}, routes)
+
+	_, err = (&Config{JWTRoutes: []string{"/hello=group:admins"}}).JWTRouteRequirements()
+	assert.ErrorContains(t, err, `"group:admins"`)
+	_, err = (&Config{JWTRoutes: []string{"hello"}}).JWTRouteRequirements()
+	assert.ErrorContains(t, err, "must start with a path")
 }
 
 func TestGetOsEnvIntSet(t *testing.T) {
@@ -76,6 +178,55 @@ func TestGetOsEnvIntSetBadInt(t *testing.T) {
 	assert.Equal(t, defaultValueInt, result)
 }
 
+func TestGetOsEnvBoolSet(t *testing.T) {
+	t.Setenv(key, "true")
+
+	result := getOsEnvBool(key, false)
+	assert.True(t, result)
+}
+
+func TestGetOsEnvBoolSetBadBool(t *testing.T) {
+	t.Setenv(key, "maybe")
+
+	result := getOsEnvBool(key, true)
+	assert.True(t, result)
+}
+
+func TestGetOsEnvDurationSet(t *testing.T) {
+	t.Setenv(key, "15s")
+
+	result := getOsEnvDuration(key, time.Second)
+	assert.Equal(t, 15*time.Second, result)
+}
+
+func TestGetOsEnvDurationUnset(t *testing.T) {
+	t.Parallel()
+
+	result := getOsEnvDuration(key, time.Second)
+	assert.Equal(t, time.Second, result)
+}
+
+func TestGetOsEnvDurationSetBadDuration(t *testing.T) {
+	t.Setenv(key, "15")
+
+	result := getOsEnvDuration(key, time.Second)
+	assert.Equal(t, time.Second, result)
+}
+
+func TestGetOsEnvListSet(t *testing.T) {
+	t.Setenv(key, " /hello, ,/metrics ")
+
+	result := getOsEnvList(key)
+	assert.Equal(t, []string{"/hello", "/metrics"}, result)
+}
+
+func TestGetOsEnvListUnset(t *testing.T) {
+	t.Parallel()
+
+	result := getOsEnvList(key)
+	assert.Nil(t, result)
+}
+
 func TestGetOsEnvStringSet(t *testing.T) {
 	t.Setenv(key, "someValue")
 
@@ -94,6 +245,10 @@ func TestInvalidTLSConfigs(t *testing.T) {
 	tlsConfig := NewTLSConfig()
 	assert.Nil(t, tlsConfig)
 
+	caCertPool, err := CACertPool()
+	assert.NotNil(t, err)
+	assert.Nil(t, caCertPool)
+
 	logMtlsConfig := LogmTLSConfig()
 	assert.Nil(t, logMtlsConfig)
 }
@@ -111,9 +266,18 @@ func TestTLSConfigs(t *testing.T) {
 	tlsConfig := NewTLSConfig()
 	assert.NotNil(t, tlsConfig)
 
+	caCertPool, err := CACertPool()
+	assert.Nil(t, err)
+	assert.NotNil(t, caCertPool)
+
 	logMtlsConfig := LogmTLSConfig()
 	assert.NotNil(t, logMtlsConfig)
 
+	assert.Empty(t, IamTLSConfig().Certificates, "IAM TLS should not present the app certificate")
+	t.Setenv("IAM_CLIENT_AUTH_METHOD", TLSClientAuth)
+	ReloadAppConfig()
+	assert.Len(t, IamTLSConfig().Certificates, 1, "IAM TLS should present the app certificate")
+
 	t.Cleanup(func() {
 		e := os.Remove("cacert.crt")
 		assert.Nil(t, e, fmt.Sprintf("error deleting cacert.crt: %v", e))
@@ -0,0 +1,19 @@
+package configuration
+
+import "runtime/debug"
+
+// version is set when building a release, with
+// -ldflags "-X eric-oss-hello-world-go-app/src/internal/configuration.version=$(cat version)"
+var version string
+
+// BuildVersion Returns the version the app was built as, from the version file when the build set it,
+// otherwise from the module build info, or "unknown" for a development build
+func BuildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
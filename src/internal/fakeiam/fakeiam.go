@@ -0,0 +1,447 @@
+// Package fakeiam provides an in-process IAM serving the Keycloak endpoints used by the app,
+// with real signed tokens and scripted failures, for tests and local development
+package fakeiam
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+)
+
+// Endpoints of the realm, relative to its path, as served by Keycloak
+const (
+	TokenEndpoint         = "/protocol/openid-connect/token"
+	IntrospectionEndpoint = "/protocol/openid-connect/token/introspect"
+	RevocationEndpoint    = "/protocol/openid-connect/revoke"
+	JWKSEndpoint          = "/protocol/openid-connect/certs"
+	DiscoveryEndpoint     = "/.well-known/openid-configuration"
+)
+
+const (
+	defaultTokenLifetime = 5 * time.Minute
+	tokenExchangeGrant   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType      = "urn:ietf:params:oauth:token-type:access_token"
+	clientAssertionType  = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+var errRevoked = errors.New("token is revoked")
+
+// Client is a client registered at the IAM
+type Client struct {
+	Secret string
+	// Roles are put in the realm_access claim of the tokens issued to the client
+	Roles []string
+	// Scopes are granted when a token request names none
+	Scopes []string
+}
+
+// Failure scripts the answer to a single request, see IAM.Script
+type Failure struct {
+	// Latency is waited before answering, unless the request is cancelled first
+	Latency time.Duration
+	// Status, when set, is answered instead of handling the request
+	Status int
+	// Body, when set, is answered instead of handling the request, for example invalid JSON
+	Body string
+	// Expired makes the tokens issued by the request already expired
+	Expired bool
+}
+
+type signingKey struct {
+	id  string
+	key *ecdsa.PrivateKey
+}
+
+// IAM serves a single realm. Its zero value is not usable, see New and NewServer.
+type IAM struct {
+	realm string
+
+	mu       sync.Mutex
+	baseURL  string
+	keys     []signingKey
+	clients  map[string]Client
+	lifetime time.Duration
+	script   []Failure
+	revoked  map[string]bool
+	requests map[string]int
+	now      func() time.Time
+}
+
+// New Create an IAM for realm, reachable at baseURL
+func New(baseURL, realm string) *IAM {
+	iam := &IAM{
+		realm:    realm,
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		clients:  map[string]Client{},
+		lifetime: defaultTokenLifetime,
+		revoked:  map[string]bool{},
+		requests: map[string]int{},
+		now:      time.Now,
+	}
+	iam.RotateKey()
+	return iam
+}
+
+// Server is an IAM listening on a local port
+type Server struct {
+	*IAM
+	*httptest.Server
+}
+
+// NewServer starts an IAM for the master realm on a local port, it is stopped by Close
+func NewServer() *Server {
+	iam := New("", "master")
+	server := httptest.NewServer(iam)
+	iam.mu.Lock()
+	iam.baseURL = server.URL
+	iam.mu.Unlock()
+	return &Server{IAM: iam, Server: server}
+}
+
+// RealmURL Returns the URL of the realm, which is the issuer of its tokens
+func (i *IAM) RealmURL() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.realmURL()
+}
+
+func (i *IAM) realmURL() string {
+	return i.baseURL + "/auth/realms/" + url.PathEscape(i.realm)
+}
+
+// AddClient registers a client, replacing a client with the same ID
+func (i *IAM) AddClient(id string, client Client) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.clients[id] = client
+}
+
+// SetTokenLifetime changes the lifetime of the tokens issued from now on
+func (i *IAM) SetTokenLifetime(lifetime time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lifetime = lifetime
+}
+
+// RotateKey signs the tokens issued from now on with a new key, the previous keys stay published
+func (i *IAM) RotateKey() {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic("fakeiam: could not generate a signing key: " + err.Error())
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.keys = append(i.keys, signingKey{id: randomID(), key: key})
+}
+
+// Script makes the next requests, whichever endpoint they are for, answer as described by failures, in order
+func (i *IAM) Script(failures ...Failure) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.script = append(i.script, failures...)
+}
+
+// Requests returns how many requests were received for an endpoint, e.g. TokenEndpoint
+func (i *IAM) Requests(endpoint string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.requests[endpoint]
+}
+
+// IssueToken signs a token with the claims of a token issued by the realm, overridden by claims.
+// A nil value removes a claim.
+func (i *IAM) IssueToken(claims map[string]any) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := i.now()
+	payload := map[string]any{
+		"iss": i.realmURL(),
+		"sub": "user",
+		"iat": now.Unix(),
+		"exp": now.Add(i.lifetime).Unix(),
+		"jti": randomID(),
+	}
+	for name, value := range claims {
+		if value == nil {
+			delete(payload, name)
+			continue
+		}
+		payload[name] = value
+	}
+	return i.sign(payload)
+}
+
+// sign the caller holds i.mu
+func (i *IAM) sign(payload map[string]any) string {
+	current := i.keys[len(i.keys)-1]
+	token, err := jwt.Sign(jwt.Header{KeyID: current.id}, payload, current.key)
+	if err != nil {
+		panic("fakeiam: could not sign a token: " + err.Error())
+	}
+	return token
+}
+
+// ServeHTTP serves the endpoints of the realm
+func (i *IAM) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	realmPath := "/auth/realms/" + url.PathEscape(i.realm)
+	endpoint := strings.TrimPrefix(req.URL.EscapedPath(), realmPath)
+	if endpoint == req.URL.EscapedPath() {
+		http.NotFound(resp, req)
+		return
+	}
+
+	i.mu.Lock()
+	i.requests[endpoint]++
+	var failure Failure
+	if len(i.script) > 0 {
+		failure, i.script = i.script[0], i.script[1:]
+	}
+	i.mu.Unlock()
+
+	if failure.Latency > 0 {
+		// the body is read first, otherwise the server does not notice a client going away
+		_ = req.ParseForm()
+		timer := time.NewTimer(failure.Latency)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	if failure.Status != 0 || failure.Body != "" {
+		status := failure.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(status)
+		_, _ = resp.Write([]byte(failure.Body))
+		return
+	}
+
+	switch {
+	case endpoint == TokenEndpoint && req.Method == http.MethodPost:
+		i.token(resp, req, failure.Expired)
+	case endpoint == IntrospectionEndpoint && req.Method == http.MethodPost:
+		i.introspect(resp, req)
+	case endpoint == RevocationEndpoint && req.Method == http.MethodPost:
+		i.revoke(resp, req)
+	case endpoint == JWKSEndpoint && req.Method == http.MethodGet:
+		i.jwks(resp)
+	case endpoint == DiscoveryEndpoint && req.Method == http.MethodGet:
+		i.discovery(resp)
+	default:
+		http.NotFound(resp, req)
+	}
+}
+
+func (i *IAM) token(resp http.ResponseWriter, req *http.Request, expired bool) {
+	clientID, client, ok := i.authenticate(req)
+	if !ok {
+		writeError(resp, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+
+	scope := req.PostForm.Get("scope")
+	if scope == "" {
+		scope = strings.Join(client.Scopes, " ")
+	}
+	claims := map[string]any{
+		"sub":          "service-account-" + clientID,
+		"azp":          clientID,
+		"scope":        scope,
+		"realm_access": map[string]any{"roles": client.Roles},
+	}
+	if audience := req.PostForm["audience"]; len(audience) > 0 {
+		claims["aud"] = audience
+	}
+
+	switch grant := req.PostForm.Get("grant_type"); grant {
+	case "client_credentials":
+	case tokenExchangeGrant:
+		subject, err := i.validate(req.PostForm.Get("subject_token"))
+		if err != nil || req.PostForm.Get("subject_token_type") != accessTokenType {
+			writeError(resp, http.StatusBadRequest, "invalid_request", "Invalid subject token")
+			return
+		}
+		claims["sub"] = subject["sub"]
+		claims["realm_access"] = subject["realm_access"]
+	default:
+		writeError(resp, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type "+grant)
+		return
+	}
+
+	i.mu.Lock()
+	lifetime := i.lifetime
+	if expired {
+		claims["iat"] = i.now().Add(-2 * lifetime).Unix()
+		claims["exp"] = i.now().Add(-lifetime).Unix()
+	}
+	i.mu.Unlock()
+	response := map[string]any{
+		"access_token": i.IssueToken(claims),
+		"token_type":   "Bearer",
+		"expires_in":   int(lifetime.Seconds()),
+		"scope":        scope,
+	}
+	if req.PostForm.Get("grant_type") == tokenExchangeGrant {
+		response["issued_token_type"] = accessTokenType
+	}
+	writeJSON(resp, response)
+}
+
+func (i *IAM) introspect(resp http.ResponseWriter, req *http.Request) {
+	if _, _, ok := i.authenticate(req); !ok {
+		writeError(resp, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+	claims, err := i.validate(req.PostForm.Get("token"))
+	if err != nil {
+		writeJSON(resp, map[string]any{"active": false})
+		return
+	}
+	claims["active"] = true
+	claims["client_id"] = claims["azp"]
+	claims["token_type"] = "Bearer"
+	writeJSON(resp, claims)
+}
+
+func (i *IAM) revoke(resp http.ResponseWriter, req *http.Request) {
+	if _, _, ok := i.authenticate(req); !ok {
+		writeError(resp, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+	// RFC 7009 answers 200 for tokens that are invalid already
+	if claims, err := i.validate(req.PostForm.Get("token")); err == nil {
+		if id, ok := claims["jti"].(string); ok {
+			i.mu.Lock()
+			i.revoked[id] = true
+			i.mu.Unlock()
+		}
+	}
+	resp.WriteHeader(http.StatusOK)
+}
+
+func (i *IAM) jwks(resp http.ResponseWriter) {
+	i.mu.Lock()
+	keys := make([]jwt.JWK, 0, len(i.keys))
+	for _, k := range i.keys {
+		keys = append(keys, jwt.JWK{
+			KeyType: "EC", KeyID: k.id, Use: "sig", Algorithm: jwt.ES256, Curve: "P-256",
+			X: base64.RawURLEncoding.EncodeToString(k.key.X.FillBytes(make([]byte, 32))),
+			Y: base64.RawURLEncoding.EncodeToString(k.key.Y.FillBytes(make([]byte, 32))),
+		})
+	}
+	i.mu.Unlock()
+	writeJSON(resp, jwt.JWKS{Keys: keys})
+}
+
+func (i *IAM) discovery(resp http.ResponseWriter) {
+	realmURL := i.RealmURL()
+	writeJSON(resp, map[string]any{
+		"issuer":                 realmURL,
+		"token_endpoint":         realmURL + TokenEndpoint,
+		"jwks_uri":               realmURL + JWKSEndpoint,
+		"introspection_endpoint": realmURL + IntrospectionEndpoint,
+		"revocation_endpoint":    realmURL + RevocationEndpoint,
+		"grant_types_supported":  []string{"client_credentials", tokenExchangeGrant},
+		"token_endpoint_auth_methods_supported": []string{
+			"client_secret_post", "client_secret_basic", "private_key_jwt", "tls_client_auth",
+		},
+	})
+}
+
+// authenticate accepts a known client with its secret in the form or in a Basic header. The assertion of
+// private_key_jwt is not verified, and for tls_client_auth any client certificate is accepted.
+func (i *IAM) authenticate(req *http.Request) (string, Client, bool) {
+	if err := req.ParseForm(); err != nil {
+		return "", Client{}, false
+	}
+	clientID, secret, basic := req.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form encodes the credentials before the Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+
+	i.mu.Lock()
+	client, ok := i.clients[clientID]
+	i.mu.Unlock()
+	switch {
+	case !ok:
+		return "", Client{}, false
+	case req.PostForm.Get("client_assertion_type") == clientAssertionType:
+		return clientID, client, req.PostForm.Get("client_assertion") != ""
+	case secret == "" && req.TLS != nil && len(req.TLS.PeerCertificates) > 0:
+		return clientID, client, true
+	}
+	return clientID, client, secret != "" && secret == client.Secret
+}
+
+// validate returns the claims of a token issued by the realm that is neither expired nor revoked
+func (i *IAM) validate(token string) (map[string]any, error) {
+	parsed, err := jwt.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, k := range i.keys {
+		if k.id != parsed.Header.KeyID {
+			continue
+		}
+		if err := parsed.Verify(&k.key.PublicKey); err != nil {
+			return nil, err
+		}
+		var claims map[string]any
+		var times jwt.Claims
+		if err := parsed.Claims(&claims); err != nil {
+			return nil, err
+		}
+		if err := parsed.Claims(&times); err != nil {
+			return nil, err
+		}
+		if err := times.ValidateTime(i.now(), 0); err != nil {
+			return nil, err
+		}
+		if i.revoked[times.ID] {
+			return nil, errRevoked
+		}
+		return claims, nil
+	}
+	return nil, jwt.ErrSignature
+}
+
+func writeJSON(resp http.ResponseWriter, v any) {
+	resp.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(resp).Encode(v)
+}
+
+func writeError(resp http.ResponseWriter, status int, code, description string) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	_ = json.NewEncoder(resp).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+func randomID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic("fakeiam: could not generate an ID: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
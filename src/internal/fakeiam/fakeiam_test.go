@@ -0,0 +1,176 @@
+package fakeiam_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) *fakeiam.Server {
+	t.Helper()
+	server := fakeiam.NewServer()
+	t.Cleanup(server.Close)
+	t.Cleanup(request.Reload)
+	server.AddClient("app", fakeiam.Client{Secret: "secret", Roles: []string{"reader"}, Scopes: []string{"openid"}})
+	return server
+}
+
+// verify checks token against the keys published by the server and returns its claims
+func verify(t *testing.T, server *fakeiam.Server, token string) jwt.Claims {
+	t.Helper()
+	var jwks jwt.JWKS
+	assert.Nil(t, request.FetchJSON(context.Background(), server.RealmURL()+fakeiam.JWKSEndpoint, &jwks))
+	parsed, err := jwt.Parse(token)
+	assert.Nil(t, err)
+	var claims jwt.Claims
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyID != parsed.Header.KeyID {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		assert.Nil(t, err)
+		assert.Nil(t, parsed.Verify(key))
+		assert.Nil(t, parsed.Claims(&claims))
+		return claims
+	}
+	t.Fatalf("no published key %q", parsed.Header.KeyID)
+	return claims
+}
+
+func TestLoginIssuesSignedToken(t *testing.T) {
+	server := newServer(t)
+
+	token, err := request.Login(context.Background(), "app", "secret", server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.Equal(t, 300, token.ExpiresIn)
+	assert.Equal(t, "openid", token.Scope)
+	claims := verify(t, server, token.AccessToken)
+	assert.Equal(t, server.RealmURL(), claims.Issuer)
+	assert.Equal(t, "service-account-app", claims.Subject)
+	assert.Nil(t, claims.ValidateTime(time.Now(), 0))
+	assert.Equal(t, 1, server.Requests(fakeiam.TokenEndpoint))
+}
+
+func TestLoginWithWrongSecret(t *testing.T) {
+	server := newServer(t)
+
+	_, err := request.Login(context.Background(), "app", "wrong", server.URL)
+
+	var oauthErr *request.OAuthError
+	assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+	assert.Equal(t, "invalid_client", oauthErr.Code)
+}
+
+func TestKeyRotationKeepsOldKeysPublished(t *testing.T) {
+	server := newServer(t)
+	before := server.IssueToken(nil)
+
+	server.RotateKey()
+	after := server.IssueToken(map[string]any{"sub": "other", "jti": nil})
+
+	assert.Equal(t, "user", verify(t, server, before).Subject)
+	claims := verify(t, server, after)
+	assert.Equal(t, "other", claims.Subject)
+	assert.Empty(t, claims.ID)
+}
+
+func TestDiscovery(t *testing.T) {
+	server := newServer(t)
+
+	metadata, err := request.Discover(context.Background(), server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, server.RealmURL(), metadata.Issuer)
+	assert.Equal(t, server.RealmURL()+fakeiam.TokenEndpoint, metadata.TokenEndpoint)
+	assert.Equal(t, server.RealmURL()+fakeiam.IntrospectionEndpoint, metadata.IntrospectionEndpoint)
+	assert.Equal(t, server.RealmURL()+fakeiam.RevocationEndpoint, metadata.RevocationEndpoint)
+}
+
+func TestIntrospectAndRevoke(t *testing.T) {
+	server := newServer(t)
+	token := server.IssueToken(map[string]any{"sub": "user-1"})
+
+	result, err := request.Introspect(context.Background(), "app", "secret", server.URL, token)
+	assert.Nil(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, "user-1", result.Subject)
+
+	assert.Nil(t, request.Revoke(context.Background(), "app", "secret", server.URL, token, request.AccessTokenHint))
+	result, err = request.Introspect(context.Background(), "app", "secret", server.URL, token)
+	assert.Nil(t, err)
+	assert.False(t, result.Active, "A revoked token should not be active")
+
+	result, err = request.Introspect(context.Background(), "app", "secret", server.URL, "not-a-token")
+	assert.Nil(t, err)
+	assert.False(t, result.Active)
+}
+
+func TestTokenExchange(t *testing.T) {
+	server := newServer(t)
+	subject := server.IssueToken(map[string]any{"sub": "user-1"})
+
+	token, err := request.Exchange(context.Background(), "app", "secret", server.URL,
+		request.ExchangeRequest{SubjectToken: subject, Audience: []string{"inventory"}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, request.AccessTokenType, token.IssuedTokenType)
+	claims := verify(t, server, token.AccessToken)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.True(t, claims.Audience.Contains("inventory"))
+
+	_, err = request.Exchange(context.Background(), "app", "secret", server.URL,
+		request.ExchangeRequest{SubjectToken: "forged"})
+	var oauthErr *request.OAuthError
+	assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+}
+
+func TestScriptedServerErrorIsRetried(t *testing.T) {
+	server := newServer(t)
+	server.Script(fakeiam.Failure{Status: http.StatusServiceUnavailable})
+
+	_, err := request.Login(context.Background(), "app", "secret", server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, server.Requests(fakeiam.TokenEndpoint))
+}
+
+func TestScriptedInvalidJSON(t *testing.T) {
+	server := newServer(t)
+	server.Script(fakeiam.Failure{Body: `{"access_token":`})
+
+	_, err := request.Login(context.Background(), "app", "secret", server.URL)
+
+	assert.ErrorIs(t, err, request.ErrDecode)
+}
+
+func TestScriptedLatency(t *testing.T) {
+	server := newServer(t)
+	server.Script(fakeiam.Failure{Latency: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := request.Login(ctx, "app", "secret", server.URL)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestScriptedExpiredToken(t *testing.T) {
+	server := newServer(t)
+	server.Script(fakeiam.Failure{Expired: true})
+
+	token, err := request.Login(context.Background(), "app", "secret", server.URL)
+
+	assert.Nil(t, err)
+	claims := verify(t, server, token.AccessToken)
+	assert.ErrorIs(t, claims.ValidateTime(time.Now(), 0), jwt.ErrExpired)
+}
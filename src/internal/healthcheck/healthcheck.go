@@ -0,0 +1,245 @@
+// Package healthcheck provides a registry of component checks backing the liveness, readiness and startup probes
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Probe identifies which Kubernetes probe a check contributes to
+type Probe string
+
+const (
+	// Liveness the process is running and able to serve requests
+	Liveness Probe = "live"
+	// Readiness the app and its dependencies are able to handle traffic
+	Readiness Probe = "ready"
+	// Startup the app has finished initializing
+	Startup Probe = "startup"
+)
+
+const (
+	// StatusUp component is healthy
+	StatusUp = "UP"
+	// StatusDown component is unhealthy
+	StatusDown = "DOWN"
+)
+
+const (
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = 5 * time.Second
+)
+
+// Checker reports the health of a single component, returning nil when it is healthy
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type checkerFunc struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (c *checkerFunc) Name() string                    { return c.name }
+func (c *checkerFunc) Check(ctx context.Context) error { return c.check(ctx) }
+
+// NewChecker Create a Checker from a name and a check function
+func NewChecker(name string, check func(ctx context.Context) error) Checker {
+	return &checkerFunc{name: name, check: check}
+}
+
+// Options control how a registered Checker is run
+type Options struct {
+	// Timeout bounds a single run of the check, defaults to 2s
+	Timeout time.Duration
+	// CacheTTL is how long a result is reused before the check is run again, defaults to 5s
+	CacheTTL time.Duration
+	// Optional checks are reported but do not fail the probe
+	Optional bool
+}
+
+// ComponentStatus is the result of a single check
+type ComponentStatus struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Optional  bool   `json:"optional,omitempty"`
+	CheckedAt string `json:"checked_at"`
+}
+
+// Report is the combined result of all checks registered for a probe
+type Report struct {
+	Status     string            `json:"status"`
+	Components []ComponentStatus `json:"components"`
+}
+
+type entry struct {
+	checker Checker
+	options Options
+
+	mu        sync.Mutex
+	result    ComponentStatus
+	checkedAt time.Time
+}
+
+// Registry holds the checks registered for each probe
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[Probe][]*entry
+}
+
+// NewRegistry Create an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{entries: map[Probe][]*entry{}}
+}
+
+// Register adds a Checker to the given probes, sharing cached results between them
+func (r *Registry) Register(checker Checker, options Options, probes ...Probe) {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.CacheTTL < 0 {
+		options.CacheTTL = 0
+	} else if options.CacheTTL == 0 {
+		options.CacheTTL = defaultCacheTTL
+	}
+
+	e := &entry{checker: checker, options: options}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, probe := range probes {
+		r.entries[probe] = append(r.entries[probe], e)
+	}
+}
+
+// Check runs the checks registered for a probe concurrently and combines their results
+func (r *Registry) Check(ctx context.Context, probe Probe) Report {
+	r.mu.RLock()
+	entries := r.entries[probe]
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Components: make([]ComponentStatus, len(entries))}
+
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			report.Components[i] = e.run(ctx)
+		}(i, e)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status != StatusUp && !component.Optional {
+			report.Status = StatusDown
+		}
+	}
+
+	return report
+}
+
+// Handler serves the JSON report of a probe, answering 503 when it is failing
+func (r *Registry) Handler(probe Probe) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		report := r.Check(req.Context(), probe)
+
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(status)
+		_ = json.NewEncoder(resp).Encode(report)
+	}
+}
+
+// run returns the cached result when still fresh, otherwise it runs the check,
+// holding the lock so concurrent probes do not run the same check twice
+func (e *entry) run(ctx context.Context) ComponentStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.checkedAt.IsZero() && time.Since(e.checkedAt) < e.options.CacheTTL {
+		return e.result
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, e.options.Timeout)
+	defer cancel()
+
+	result := ComponentStatus{
+		Name:     e.checker.Name(),
+		Status:   StatusUp,
+		Optional: e.options.Optional,
+	}
+	if err := runWithContext(checkCtx, e.checker); err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+
+	e.checkedAt = time.Now()
+	result.CheckedAt = e.checkedAt.UTC().Format(time.RFC3339)
+	e.result = result
+
+	return result
+}
+
+// runWithContext stops waiting for a check that ignores its context once the timeout expires
+func runWithContext(ctx context.Context, checker Checker) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- checker.Check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out: %w", ctx.Err())
+	}
+}
+
+// CheckHTTP reports an error when url cannot be reached or answers with a server error.
+// Any other status, such as 405 from a token endpoint, shows the dependency is available.
+func CheckHTTP(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck //error has no impact
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.New("unexpected response: " + resp.Status)
+	}
+
+	return nil
+}
+
+// CheckFiles reports an error when any of the files cannot be read
+func CheckFiles(paths ...string) error {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return errors.New(path + " is a directory")
+		}
+	}
+
+	return nil
+}
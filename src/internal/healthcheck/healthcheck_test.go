@@ -0,0 +1,154 @@
+package healthcheck_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/healthcheck"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmptyProbeIsUp(t *testing.T) {
+	t.Parallel()
+	registry := healthcheck.NewRegistry()
+
+	report := registry.Check(context.Background(), healthcheck.Liveness)
+
+	assert.Equal(t, healthcheck.StatusUp, report.Status)
+	assert.Empty(t, report.Components)
+}
+
+func TestFailingCheckFailsProbe(t *testing.T) {
+	t.Parallel()
+	registry := healthcheck.NewRegistry()
+	registry.Register(healthcheck.NewChecker("ok", func(ctx context.Context) error { return nil }),
+		healthcheck.Options{}, healthcheck.Readiness)
+	registry.Register(healthcheck.NewChecker("broken", func(ctx context.Context) error { return errors.New("boom") }),
+		healthcheck.Options{}, healthcheck.Readiness)
+
+	report := registry.Check(context.Background(), healthcheck.Readiness)
+
+	assert.Equal(t, healthcheck.StatusDown, report.Status)
+	assert.Len(t, report.Components, 2)
+	assert.Equal(t, "ok", report.Components[0].Name)
+	assert.Equal(t, healthcheck.StatusUp, report.Components[0].Status)
+	assert.Equal(t, "broken", report.Components[1].Name)
+	assert.Equal(t, healthcheck.StatusDown, report.Components[1].Status)
+	assert.Equal(t, "boom", report.Components[1].Error)
+
+	// checks only contribute to the probes they were registered for
+	assert.Equal(t, healthcheck.StatusUp, registry.Check(context.Background(), healthcheck.Startup).Status)
+}
+
+func TestOptionalCheckDoesNotFailProbe(t *testing.T) {
+	t.Parallel()
+	registry := healthcheck.NewRegistry()
+	registry.Register(healthcheck.NewChecker("optional", func(ctx context.Context) error { return errors.New("boom") }),
+		healthcheck.Options{Optional: true}, healthcheck.Readiness)
+
+	report := registry.Check(context.Background(), healthcheck.Readiness)
+
+	assert.Equal(t, healthcheck.StatusUp, report.Status)
+	assert.Equal(t, healthcheck.StatusDown, report.Components[0].Status)
+	assert.True(t, report.Components[0].Optional)
+}
+
+func TestCheckTimesOut(t *testing.T) {
+	t.Parallel()
+	registry := healthcheck.NewRegistry()
+	release := make(chan struct{})
+	defer close(release)
+	registry.Register(healthcheck.NewChecker("slow", func(ctx context.Context) error {
+		<-release
+		return nil
+	}), healthcheck.Options{Timeout: 10 * time.Millisecond}, healthcheck.Readiness)
+
+	report := registry.Check(context.Background(), healthcheck.Readiness)
+
+	assert.Equal(t, healthcheck.StatusDown, report.Status)
+	assert.Contains(t, report.Components[0].Error, "check timed out")
+}
+
+func TestResultsAreCached(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	checker := healthcheck.NewChecker("counted", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	cached := healthcheck.NewRegistry()
+	cached.Register(checker, healthcheck.Options{CacheTTL: time.Minute}, healthcheck.Startup, healthcheck.Readiness)
+	cached.Check(context.Background(), healthcheck.Startup)
+	cached.Check(context.Background(), healthcheck.Readiness)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Result should be shared between probes")
+
+	uncached := healthcheck.NewRegistry()
+	uncached.Register(checker, healthcheck.Options{CacheTTL: -1}, healthcheck.Readiness)
+	uncached.Check(context.Background(), healthcheck.Readiness)
+	uncached.Check(context.Background(), healthcheck.Readiness)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Result should not be cached with negative TTL")
+}
+
+func TestHandlerWritesJSONReport(t *testing.T) {
+	t.Parallel()
+	registry := healthcheck.NewRegistry()
+	registry.Register(healthcheck.NewChecker("broken", func(ctx context.Context) error { return errors.New("boom") }),
+		healthcheck.Options{}, healthcheck.Readiness)
+
+	tests := []struct {
+		probe  healthcheck.Probe
+		status int
+		body   string
+	}{
+		{probe: healthcheck.Liveness, status: http.StatusOK, body: healthcheck.StatusUp},
+		{probe: healthcheck.Readiness, status: http.StatusServiceUnavailable, body: healthcheck.StatusDown},
+	}
+
+	for _, testParameters := range tests {
+		response := httptest.NewRecorder()
+		registry.Handler(testParameters.probe)(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, testParameters.status, response.Code)
+		assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+		var report healthcheck.Report
+		assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &report))
+		assert.Equal(t, testParameters.body, report.Status)
+	}
+}
+
+func TestCheckHTTP(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/token":
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	assert.Nil(t, healthcheck.CheckHTTP(context.Background(), server.Client(), server.URL+"/token"))
+	assert.NotNil(t, healthcheck.CheckHTTP(context.Background(), server.Client(), server.URL+"/down"))
+	assert.NotNil(t, healthcheck.CheckHTTP(context.Background(), server.Client(), "http://127.0.0.1:0"))
+}
+
+func TestCheckFiles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	file := dir + "/cert.pem"
+	assert.Nil(t, os.WriteFile(file, []byte("cert"), 0o600))
+
+	assert.Nil(t, healthcheck.CheckFiles(file))
+	assert.NotNil(t, healthcheck.CheckFiles(file, dir+"/missing.pem"))
+	assert.NotNil(t, healthcheck.CheckFiles(dir))
+}
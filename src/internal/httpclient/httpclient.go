@@ -0,0 +1,79 @@
+// Package httpclient provides the pooled HTTP clients shared by all outbound calls
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+)
+
+const (
+	keepAlive    = 30 * time.Second
+	maxIdleConns = 100
+)
+
+// Client hands out an *http.Client with a pooled transport, which Reload replaces
+// when the TLS configuration or the timeouts change
+type Client struct {
+	tlsConfig func() *tls.Config
+	current   atomic.Pointer[http.Client]
+}
+
+// New Create a Client whose transport uses the TLS configuration returned by tlsConfig,
+// and the timeouts from configuration.Current()
+func New(tlsConfig func() *tls.Config) *Client {
+	client := &Client{tlsConfig: tlsConfig}
+	client.Reload()
+	return client
+}
+
+// HTTPClient returns the current client, it must not be kept across reloads
+func (c *Client) HTTPClient() *http.Client {
+	return c.current.Load()
+}
+
+// Do sends the request with the current client
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	return c.HTTPClient().Do(req)
+}
+
+// Reload builds a new transport from the current TLS configuration and timeouts. Requests already
+// in flight complete on the previous transport, whose idle connections are closed.
+func (c *Client) Reload() {
+	previous := c.current.Swap(newHTTPClient(configuration.Current(), c.tlsConfig()))
+	if previous != nil {
+		previous.CloseIdleConnections()
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (c *Client) CloseIdleConnections() {
+	c.HTTPClient().CloseIdleConnections()
+}
+
+func newHTTPClient(config *configuration.Config, tlsConfig *tls.Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   config.HTTPDialTimeout,
+		KeepAlive: keepAlive,
+	}
+
+	return &http.Client{
+		Timeout: config.HTTPClientTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   config.HTTPTLSHandshakeTimeout,
+			ResponseHeaderTimeout: config.HTTPResponseHeaderTimeout,
+			IdleConnTimeout:       config.HTTPIdleConnTimeout,
+			MaxIdleConns:          maxIdleConns,
+			MaxIdleConnsPerHost:   config.HTTPMaxIdleConnsPerHost,
+			// a custom TLSClientConfig disables HTTP/2 unless it is asked for explicitly
+			ForceAttemptHTTP2: true,
+		},
+	}
+}
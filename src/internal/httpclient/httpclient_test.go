@@ -0,0 +1,96 @@
+package httpclient_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/httpclient"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUsesConfiguredTimeouts(t *testing.T) {
+	t.Setenv("HTTP_DIAL_TIMEOUT", "1s")
+	t.Setenv("HTTP_TLS_HANDSHAKE_TIMEOUT", "2s")
+	t.Setenv("HTTP_RESPONSE_HEADER_TIMEOUT", "3s")
+	t.Setenv("HTTP_CLIENT_TIMEOUT", "4s")
+	t.Setenv("HTTP_IDLE_CONN_TIMEOUT", "5s")
+	t.Setenv("HTTP_MAX_IDLE_CONNS_PER_HOST", "6")
+	configuration.ReloadAppConfig()
+	t.Cleanup(configuration.ReloadAppConfig)
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
+
+	client := httpclient.New(func() *tls.Config { return tlsConfig }).HTTPClient()
+
+	assert.Equal(t, 4*time.Second, client.Timeout)
+	transport, ok := client.Transport.(*http.Transport)
+	assert.True(t, ok, "Transport should be an *http.Transport")
+	assert.Same(t, tlsConfig, transport.TLSClientConfig)
+	assert.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 3*time.Second, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 5*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 6, transport.MaxIdleConnsPerHost)
+	assert.True(t, transport.ForceAttemptHTTP2)
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	client := httpclient.New(func() *tls.Config { return nil })
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, server.URL, http.NoBody)
+		assert.Nil(t, err)
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close() //nolint:errcheck,gosec //error has no impact
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections), "Keep-alive connection should be reused")
+}
+
+func TestClientUsesHTTP2AndReloadsTLSConfig(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	var trusted atomic.Bool
+	client := httpclient.New(func() *tls.Config {
+		pool := x509.NewCertPool()
+		if trusted.Load() {
+			pool.AddCert(server.Certificate())
+		}
+		return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	})
+	get := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, server.URL, http.NoBody)
+		assert.Nil(t, err)
+		return client.Do(req)
+	}
+
+	_, err := get()
+	assert.NotNil(t, err, "Server certificate should not be trusted yet")
+
+	trusted.Store(true)
+	client.Reload()
+	resp, err := get()
+
+	assert.Nil(t, err)
+	defer resp.Body.Close() //nolint:errcheck //error has no impact
+	assert.Equal(t, 2, resp.ProtoMajor, "HTTP/2 should be negotiated")
+}
@@ -0,0 +1,152 @@
+// Package jwt signs JSON Web Tokens (RFC 7519) in the JWS compact serialization, and parses, verifies
+// and validates the tokens of the IAM against its JSON Web Keys
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Signing algorithms from RFC 7518
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// ErrUnsupportedKey the key type or the algorithm is not supported
+var ErrUnsupportedKey = errors.New("unsupported key")
+
+// Header is the JOSE header of a signed token
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	// X5TS256 is the base64url SHA-256 thumbprint of the certificate of the signing key
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// Audience is a single audience or a list of them, see RFC 7519 section 4.1.3
+type Audience []string
+
+// MarshalJSON writes a single audience as a string
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON accepts a string or an array of strings
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether audience is one of a
+func (a Audience) Contains(audience string) bool {
+	for _, item := range a {
+		if item == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims of RFC 7519 section 4.1, times are in seconds since the epoch
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Algorithm returns the signing algorithm used for key
+func Algorithm(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve.Params().BitSize == 256 {
+			return ES256, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %T", ErrUnsupportedKey, key.Public())
+}
+
+// Sign returns the token carrying claims, signed with key. The algorithm of header is set from the key.
+func Sign(header Header, claims any, key crypto.Signer) (string, error) {
+	alg, err := Algorithm(key)
+	if err != nil {
+		return "", err
+	}
+	header.Algorithm = alg
+	if header.Type == "" {
+		header.Type = "JWT"
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("signing token failed: %w", err)
+	}
+	if alg == ES256 {
+		// crypto.Signer returns an ASN.1 signature, JWS uses the fixed size concatenation of r and s
+		if signature, err = rawECDSASignature(signature); err != nil {
+			return "", err
+		}
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+// Thumbprint returns the base64url SHA-256 digest of a DER certificate, as used by x5t#S256
+func Thumbprint(der []byte) string {
+	digest := sha256.Sum256(der)
+	return encode(digest[:])
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func rawECDSASignature(der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+	return raw, nil
+}
@@ -0,0 +1,90 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func splitToken(t *testing.T, token string) (header, claims map[string]any, signingInput string, signature []byte) {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+	for i, target := range []*map[string]any{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(data, target))
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.Nil(t, err)
+	return header, claims, parts[0] + "." + parts[1], signature
+}
+
+func TestSignRS256(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	token, err := jwt.Sign(jwt.Header{KeyID: "key1"}, jwt.Claims{Issuer: "app", Audience: jwt.Audience{"iam"}}, key)
+	assert.Nil(t, err)
+
+	header, claims, signingInput, signature := splitToken(t, token)
+	assert.Equal(t, map[string]any{"alg": "RS256", "typ": "JWT", "kid": "key1"}, header)
+	assert.Equal(t, map[string]any{"iss": "app", "aud": "iam"}, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+}
+
+func TestSignES256(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	token, err := jwt.Sign(jwt.Header{}, jwt.Claims{Subject: "app"}, key)
+	assert.Nil(t, err)
+
+	header, _, signingInput, signature := splitToken(t, token)
+	assert.Equal(t, "ES256", header["alg"])
+	assert.Len(t, signature, 64)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	assert.True(t, ecdsa.Verify(&key.PublicKey, digest[:], r, s))
+}
+
+func TestSignUnsupportedKey(t *testing.T) {
+	t.Parallel()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	_, err = jwt.Sign(jwt.Header{}, jwt.Claims{}, key)
+
+	assert.ErrorIs(t, err, jwt.ErrUnsupportedKey)
+}
+
+func TestAudience(t *testing.T) {
+	t.Parallel()
+	var single, list jwt.Audience
+	assert.Nil(t, json.Unmarshal([]byte(`"a"`), &single))
+	assert.Nil(t, json.Unmarshal([]byte(`["a","b"]`), &list))
+
+	assert.Equal(t, jwt.Audience{"a"}, single)
+	assert.True(t, list.Contains("b"))
+	assert.False(t, list.Contains("c"))
+	data, err := json.Marshal(list)
+	assert.Nil(t, err)
+	assert.Equal(t, `["a","b"]`, string(data))
+	assert.NotNil(t, json.Unmarshal([]byte(`1`), &single))
+}
@@ -0,0 +1,167 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMalformed the token is not a JWS in compact serialization
+	ErrMalformed = errors.New("malformed token")
+	// ErrSignature the signature does not match the token and the key
+	ErrSignature = errors.New("invalid token signature")
+	// ErrExpired the token is past its exp claim
+	ErrExpired = errors.New("token is expired")
+	// ErrNoExpiry the token has no exp claim, so it would never expire
+	ErrNoExpiry = errors.New("token has no expiry")
+	// ErrNotValidYet the token is before its nbf or iat claim
+	ErrNotValidYet = errors.New("token is not valid yet")
+)
+
+// Parsed is a token split into its parts, its signature is not verified yet
+type Parsed struct {
+	Header       Header
+	Payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// Parse splits and decodes token without verifying it
+func Parse(token string) (*Parsed, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+	payload, err := decode(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %w", ErrMalformed, err)
+	}
+	signature, err := decode(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrMalformed, err)
+	}
+	parsed := &Parsed{Payload: payload, signingInput: parts[0] + "." + parts[1], signature: signature}
+	if err := json.Unmarshal(headerJSON, &parsed.Header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+	return parsed, nil
+}
+
+// Verify checks the signature with key, the algorithm of the header must match the key type
+func (p *Parsed) Verify(key crypto.PublicKey) error {
+	digest := sha256.Sum256([]byte(p.signingInput))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if p.Header.Algorithm != RS256 {
+			return fmt.Errorf("%w: algorithm %q for an RSA key", ErrSignature, p.Header.Algorithm)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], p.signature); err != nil {
+			return ErrSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if p.Header.Algorithm != ES256 || len(p.signature) != 64 {
+			return fmt.Errorf("%w: algorithm %q for an EC key", ErrSignature, p.Header.Algorithm)
+		}
+		r, s := new(big.Int).SetBytes(p.signature[:32]), new(big.Int).SetBytes(p.signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+}
+
+// Claims decodes the payload into v
+func (p *Parsed) Claims(v any) error {
+	if err := json.Unmarshal(p.Payload, v); err != nil {
+		return fmt.Errorf("%w: claims: %w", ErrMalformed, err)
+	}
+	return nil
+}
+
+// ValidateTime checks exp, nbf and iat against now, allowing leeway for clock skew.
+// exp is required, an access token without it would be accepted forever.
+func (c *Claims) ValidateTime(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt == 0 {
+		return ErrNoExpiry
+	}
+	if !now.Before(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotValidYet
+	}
+	if c.IssuedAt != 0 && now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrNotValidYet
+	}
+	return nil
+}
+
+// JWK is a public JSON Web Key (RFC 7517) of type RSA or EC
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey returns the RSA or P-256 public key described by the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("%w: curve %q", ErrUnsupportedKey, k.Curve)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("%w: key type %q", ErrUnsupportedKey, k.KeyType)
+}
+
+func decode(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
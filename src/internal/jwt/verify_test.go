@@ -0,0 +1,130 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func encodeCoordinate(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32)))
+}
+
+func TestVerifyRS256(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	token, err := jwt.Sign(jwt.Header{KeyID: "key1"}, jwt.Claims{Subject: "user"}, key)
+	assert.Nil(t, err)
+
+	parsed, err := jwt.Parse(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "key1", parsed.Header.KeyID)
+	assert.Nil(t, parsed.Verify(&key.PublicKey))
+	claims := jwt.Claims{}
+	assert.Nil(t, parsed.Claims(&claims))
+	assert.Equal(t, "user", claims.Subject)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, parsed.Verify(&other.PublicKey), jwt.ErrSignature)
+}
+
+func TestVerifyES256(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	token, err := jwt.Sign(jwt.Header{}, jwt.Claims{Subject: "user"}, key)
+	assert.Nil(t, err)
+
+	parsed, err := jwt.Parse(token)
+	assert.Nil(t, err)
+	assert.Nil(t, parsed.Verify(&key.PublicKey))
+
+	// a tampered payload no longer matches the signature
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+	parsed, err = jwt.Parse(strings.Join(parts, "."))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, parsed.Verify(&key.PublicKey), jwt.ErrSignature)
+}
+
+func TestVerifyRejectsAlgorithmOfOtherKeyType(t *testing.T) {
+	t.Parallel()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	token, err := jwt.Sign(jwt.Header{}, jwt.Claims{}, key)
+	assert.Nil(t, err)
+
+	parsed, err := jwt.Parse(token)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, parsed.Verify(&rsaKey.PublicKey), jwt.ErrSignature)
+
+	parsed.Header.Algorithm = "none"
+	assert.ErrorIs(t, parsed.Verify(&key.PublicKey), jwt.ErrSignature)
+}
+
+func TestParseMalformed(t *testing.T) {
+	t.Parallel()
+
+	for _, token := range []string{"", "a.b", "a.b.c.d", "!!.e30.", "e30.!!.", "bm90IGpzb24.e30."} {
+		_, err := jwt.Parse(token)
+		assert.ErrorIs(t, err, jwt.ErrMalformed, token)
+	}
+}
+
+func TestValidateTime(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1000, 0)
+
+	assert.ErrorIs(t, (&jwt.Claims{}).ValidateTime(now, 0), jwt.ErrNoExpiry)
+	assert.ErrorIs(t, (&jwt.Claims{IssuedAt: 990, NotBefore: 990}).ValidateTime(now, 0), jwt.ErrNoExpiry)
+	assert.Nil(t, (&jwt.Claims{IssuedAt: 990, NotBefore: 990, ExpiresAt: 1010}).ValidateTime(now, 0))
+	assert.ErrorIs(t, (&jwt.Claims{ExpiresAt: 1000}).ValidateTime(now, 0), jwt.ErrExpired)
+	assert.Nil(t, (&jwt.Claims{ExpiresAt: 995}).ValidateTime(now, 10*time.Second))
+	assert.ErrorIs(t, (&jwt.Claims{NotBefore: 1005, ExpiresAt: 1010}).ValidateTime(now, 0), jwt.ErrNotValidYet)
+	assert.Nil(t, (&jwt.Claims{NotBefore: 1005, ExpiresAt: 1010}).ValidateTime(now, 10*time.Second))
+	assert.ErrorIs(t, (&jwt.Claims{IssuedAt: 1020, ExpiresAt: 1030}).ValidateTime(now, 10*time.Second), jwt.ErrNotValidYet)
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	t.Parallel()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := jwt.JWK{KeyType: "RSA", N: encodeInt(rsaKey.N), E: "AQAB"}.PublicKey()
+	assert.Nil(t, err)
+	assert.True(t, rsaKey.PublicKey.Equal(key))
+
+	key, err = jwt.JWK{KeyType: "EC", Curve: "P-256", X: encodeCoordinate(ecKey.X), Y: encodeCoordinate(ecKey.Y)}.
+		PublicKey()
+	assert.Nil(t, err)
+	assert.True(t, ecKey.PublicKey.Equal(key))
+
+	// a point that is not on the curve
+	_, err = jwt.JWK{KeyType: "EC", Curve: "P-256", X: encodeCoordinate(ecKey.X), Y: encodeCoordinate(ecKey.X)}.
+		PublicKey()
+	assert.NotNil(t, err)
+	_, err = jwt.JWK{KeyType: "EC", Curve: "P-384"}.PublicKey()
+	assert.ErrorIs(t, err, jwt.ErrUnsupportedKey)
+	_, err = jwt.JWK{KeyType: "OKP"}.PublicKey()
+	assert.ErrorIs(t, err, jwt.ErrUnsupportedKey)
+}
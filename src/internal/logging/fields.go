@@ -0,0 +1,203 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorKey is the field WithError puts the error in
+const ErrorKey = "error"
+
+// Facilities of the remote log entries, see WithFacility
+const (
+	// FacilitySecurity messages about authentication and authorization
+	FacilitySecurity = "security/authorization messages"
+	// FacilityAudit messages recording what was done, for auditing
+	FacilityAudit = "log audit"
+)
+
+// Fields are key/value pairs logged with a message, on stdout and in the extra_data of the remote entry
+type Fields map[string]any
+
+// Entry is a set of fields the next message is logged with, an Entry is never modified once created
+// so it can be shared and extended
+type Entry struct {
+	fields   Fields
+	facility string
+	subject  string
+}
+
+// std is the Entry the package functions log with
+var std = &Entry{}
+
+type fieldsKey struct{}
+
+// NewContext Returns a copy of ctx carrying fields, added to those already carried,
+// for WithContext to log them with every message about the work ctx belongs to
+func NewContext(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, merge(fieldsFromContext(ctx), fields))
+}
+
+func fieldsFromContext(ctx context.Context) Fields {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).(Fields)
+	return fields
+}
+
+// WithField Returns an Entry logging key with value
+func WithField(key string, value any) *Entry {
+	return (&Entry{}).WithField(key, value)
+}
+
+// WithFields Returns an Entry logging fields
+func WithFields(fields Fields) *Entry {
+	return (&Entry{}).WithFields(fields)
+}
+
+// WithError Returns an Entry logging err under ErrorKey
+func WithError(err error) *Entry {
+	return (&Entry{}).WithError(err)
+}
+
+// WithContext Returns an Entry logging the fields ctx carries, see NewContext
+func WithContext(ctx context.Context) *Entry {
+	return (&Entry{}).WithContext(ctx)
+}
+
+// WithFacility Returns an Entry logging to facility, such as FacilitySecurity
+func WithFacility(facility string) *Entry {
+	return (&Entry{}).WithFacility(facility)
+}
+
+// WithSubject Returns an Entry logging subject, the user or client the message is about
+func WithSubject(subject string) *Entry {
+	return (&Entry{}).WithSubject(subject)
+}
+
+// WithField Returns a copy of e also logging key with value
+func (e *Entry) WithField(key string, value any) *Entry {
+	return e.WithFields(Fields{key: value})
+}
+
+// WithFields Returns a copy of e also logging fields, which replace those of e with the same key
+func (e *Entry) WithFields(fields Fields) *Entry {
+	c := *e
+	c.fields = merge(e.fields, fields)
+	return &c
+}
+
+// WithError Returns a copy of e also logging err under ErrorKey, a nil err is not logged
+func (e *Entry) WithError(err error) *Entry {
+	if err == nil {
+		return e
+	}
+	return e.WithField(ErrorKey, err)
+}
+
+// WithContext Returns a copy of e also logging the fields ctx carries
+func (e *Entry) WithContext(ctx context.Context) *Entry {
+	return e.WithFields(fieldsFromContext(ctx))
+}
+
+// WithFacility Returns a copy of e logging to facility
+func (e *Entry) WithFacility(facility string) *Entry {
+	c := *e
+	c.facility = facility
+	return &c
+}
+
+// WithSubject Returns a copy of e logging subject
+func (e *Entry) WithSubject(subject string) *Entry {
+	c := *e
+	c.subject = subject
+	return &c
+}
+
+// Error Log at Error level
+func (e *Entry) Error(msg string) {
+	e.log(ErrorLevel, msg)
+}
+
+// Warning Log at Warning level
+func (e *Entry) Warning(msg string) {
+	e.log(WarningLevel, msg)
+}
+
+// Info Log at Info level
+func (e *Entry) Info(msg string) {
+	e.log(InfoLevel, msg)
+}
+
+// Debug Log at Debug level
+func (e *Entry) Debug(msg string) {
+	e.log(DebugLevel, msg)
+}
+
+// log writes msg unless level is filtered out, the caller reported is the code calling the method
+// or function which calls log
+func (e *Entry) log(level logrus.Level, msg string) {
+	if Level() < level {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:]) // skips runtime.Callers, log and the logging method
+	e.output(level, msg, pcs[0])
+}
+
+// output writes msg and the fields of e to stdout and queues them for LOG_ENDPOINT, pc is the caller
+func (e *Entry) output(level logrus.Level, msg string, pc uintptr) {
+	fields := e.fields
+	if e.facility != "" {
+		fields = merge(fields, Fields{"facility": e.facility})
+	}
+	if e.subject != "" {
+		fields = merge(fields, Fields{"subject": e.subject})
+	}
+	logger.logrus.WithFields(logrus.Fields(fields)).Log(level, msg)
+	dispatch(msg, level, e, pc)
+}
+
+// merge Returns the fields of a replaced by those of b in a new map, a is returned when b is empty
+func merge(a, b Fields) Fields {
+	if len(b) == 0 {
+		return a
+	}
+	merged := make(Fields, len(a)+len(b))
+	for key, value := range a {
+		merged[key] = value
+	}
+	for key, value := range b {
+		merged[key] = value
+	}
+	return merged
+}
+
+// extraData converts fields to values JSON can encode, errors become their message and a value
+// that cannot be encoded is logged as printed by fmt
+func extraData(fields Fields) map[string]any {
+	if len(fields) == 0 {
+		return nil
+	}
+	data := make(map[string]any, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case error:
+			data[key] = v.Error()
+		case string, bool, int, int64, float64, nil:
+			data[key] = v
+		default:
+			if _, err := json.Marshal(v); err != nil {
+				data[key] = fmt.Sprint(v)
+			} else {
+				data[key] = v
+			}
+		}
+	}
+	return data
+}
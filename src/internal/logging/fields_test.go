@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureOutput sends the stdout log of the test to a buffer
+func captureOutput(t *testing.T) *syncBuffer {
+	t.Helper()
+	Init()
+	output := &syncBuffer{}
+	SetOutput(output)
+	return output
+}
+
+func TestWithFieldsLogsFields(t *testing.T) {
+	output := captureOutput(t)
+
+	WithFields(Fields{"user": "alice", "attempt": 2}).WithError(errors.New("boom")).Warning("login failed")
+
+	line := output.String()
+	assert.Contains(t, line, `msg="login failed"`)
+	assert.Contains(t, line, "user=alice")
+	assert.Contains(t, line, "attempt=2")
+	assert.Contains(t, line, "error=boom")
+}
+
+func TestEntryFollowsLevel(t *testing.T) {
+	output := captureOutput(t)
+
+	WithField("hidden", true).Debug("debug message")
+
+	assert.Empty(t, output.String())
+}
+
+func TestEntryIsNotModified(t *testing.T) {
+	fields := Fields{"a": 1}
+	base := WithFields(fields)
+	child := base.WithField("b", 2).WithError(nil)
+	fields["c"] = 3
+
+	assert.Equal(t, Fields{"a": 1}, base.fields)
+	assert.Equal(t, Fields{"a": 1, "b": 2}, child.fields)
+}
+
+func TestWithContext(t *testing.T) {
+	ctx := NewContext(context.Background(), Fields{"route": "/hello", "method": "POST"})
+	ctx = NewContext(ctx, Fields{"method": "GET"})
+
+	assert.Equal(t, Fields{"route": "/hello", "method": "GET"}, WithContext(ctx).fields)
+	assert.Equal(t, Fields{"route": "/hello", "method": "GET", "user": "alice"},
+		WithField("user", "alice").WithContext(ctx).fields)
+	assert.Empty(t, WithContext(context.Background()).fields)
+}
+
+func TestExtraData(t *testing.T) {
+	type point struct{ X, Y int }
+	data := extraData(Fields{
+		"error":  errors.New("boom"),
+		"count":  3,
+		"point":  point{1, 2},
+		"notify": make(chan struct{}),
+	})
+
+	encoded, err := json.Marshal(&logEntry{Message: "msg", ExtraData: data})
+	assert.Nil(t, err)
+	assert.Contains(t, string(encoded), `"error":"boom"`)
+	assert.Contains(t, string(encoded), `"count":3`)
+	assert.Contains(t, string(encoded), `"point":{"X":1,"Y":2}`)
+	assert.True(t, strings.Contains(string(encoded), `"notify":"0x`), "A value JSON cannot encode should be printed")
+
+	encoded, _ = json.Marshal(&logEntry{Message: "msg", ExtraData: extraData(nil)})
+	assert.NotContains(t, string(encoded), "extra_data")
+}
@@ -1,28 +1,46 @@
 package logging
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/httpclient"
+	"eric-oss-hello-world-go-app/src/internal/watcher"
 
 	"github.com/sirupsen/logrus"
 )
 
-var logger struct {
-	conf    *configuration.Config
-	tlsConf *tls.Config
-	logrus  *logrus.Logger
-	client  *http.Client
-	wg      sync.WaitGroup
-	level   logrus.Level
+// logger is shared with the shipper goroutines, the logrus logger is created once so Init
+// only reconfigures the one they use
+var logger = struct {
+	conf   atomic.Pointer[configuration.Config]
+	remote atomic.Pointer[remote]
+	logrus *logrus.Logger
+	level  atomic.Uint32
+	// controlMu keeps a level change and the message telling about it together
+	controlMu sync.Mutex
+}{logrus: logrus.New()}
+
+// entries ships the remote log entries in the background
+var entries = newShipper()
+
+// remote holds what dispatch needs to ship entries, swapped as a whole on Reload
+type remote struct {
+	endpoint string
+	client   *httpclient.Client
 }
 
 const (
@@ -40,70 +58,238 @@ const (
 	DebugLevel
 )
 
+// severities are the names the log control file gives to the levels, from the least verbose
+var severities = []struct {
+	name  string
+	level logrus.Level
+}{
+	{"critical", FatalLevel},
+	{"error", ErrorLevel},
+	{"warning", WarningLevel},
+	{"info", InfoLevel},
+	{"debug", DebugLevel},
+}
+
+// Severities are the severities the log control file accepts, from the least verbose
+var Severities = func() []string {
+	names := make([]string, 0, len(severities))
+	for _, severity := range severities {
+		names = append(names, severity.name)
+	}
+	return names
+}()
+
+// Severity returns the name the log control file gives to level
+func Severity(level logrus.Level) string {
+	for _, severity := range severities {
+		if severity.level == level {
+			return severity.name
+		}
+	}
+	return level.String()
+}
+
 type logControl struct {
 	Severity  string `json:"severity"`
 	Container string `json:"container"`
 }
 
+// SchemaVersion is the version of the schema of the remote log entries, sent in their version field.
+// It changes whenever a field is added, removed or changes meaning.
+const SchemaVersion = "1.0.0"
+
+// timestampFormat is RFC 3339 with milliseconds, as the log aggregator indexes it
+const timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// logEntry is a remote log entry, its fields are named as in the ADP log event schema
 type logEntry struct {
-	Timestamp string `json:"timestamp"`
-	Version   string `json:"version"`
-	Message   string `json:"message"`
-	ServiceID string `json:"service_id"`
-	Severity  string `json:"severity"`
+	Version   string         `json:"version"`
+	Timestamp string         `json:"timestamp"`
+	Severity  string         `json:"severity"`
+	ServiceID string         `json:"service_id"`
+	Message   string         `json:"message"`
+	Metadata  entryMetadata  `json:"metadata"`
+	Facility  string         `json:"facility,omitempty"`
+	Subject   string         `json:"subject,omitempty"`
+	ExtraData map[string]any `json:"extra_data,omitempty"`
+}
+
+// entryMetadata tells where a remote log entry comes from, the fields not known are left out
+type entryMetadata struct {
+	AppVersion    string `json:"application_version,omitempty"`
+	PodName       string `json:"pod_name,omitempty"`
+	PodUID        string `json:"pod_uid,omitempty"`
+	Namespace     string `json:"namespace,omitempty"`
+	NodeName      string `json:"node_name,omitempty"`
+	ContainerName string `json:"container_name,omitempty"`
+	// Function is the function which logged the entry and Caller its file and line
+	Function string `json:"function,omitempty"`
+	Caller   string `json:"caller,omitempty"`
 }
 
 // Init Initialize Logger
 func Init() {
-	logger.logrus = logrus.New()
 	SetOutput(os.Stdout)
 	SetLevel(InfoLevel)
-	logger.conf = configuration.AppConfig
-	logger.tlsConf = configuration.LogmTLSConfig()
-	logger.client = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: logger.tlsConf,
-		},
+	conf := configuration.Current()
+	logger.conf.Store(conf)
+	entries.configure(conf)
+	if err := loadRemote(); err != nil {
+		logger.logrus.Error(err)
 	}
-	data, err := os.ReadFile(logger.conf.LogControlFile)
-	if err != nil {
-		logger.logrus.Error(logger.conf.LogControlFile)
+	if err := loadSpool(); err != nil {
 		logger.logrus.Error(err)
-		logger.logrus.Warn("Could not read from LogControlFile, setting level to INFO")
-		return
+	}
+	if err := loadLogControl(); err != nil {
+		logger.logrus.Error(err)
+		logger.logrus.Warn("Could not use LogControlFile, setting level to INFO")
+	}
+}
+
+// Reload Re-read the log control file and rebuild the mTLS client from the current configuration,
+// and apply the current queue and spool settings. The previous level and client are kept when the new ones cannot be loaded.
+func Reload() error {
+	conf := configuration.Current()
+	logger.conf.Store(conf)
+	entries.configure(conf)
+	return errors.Join(loadRemote(), loadSpool(), loadLogControl())
+}
+
+func loadSpool() error {
+	conf := logger.conf.Load()
+	return entries.useSpool(conf.LogSpoolDir, int64(conf.LogSpoolMaxSize))
+}
+
+func loadRemote() error {
+	conf := logger.conf.Load()
+	tlsConf := configuration.LogmTLSConfig()
+	if tlsConf == nil {
+		if conf.LogEndpoint != "" {
+			return errors.New("could not load mTLS certificates for LOG_ENDPOINT " + conf.LogEndpoint)
+		}
+		swapRemote(nil)
+		return nil
+	}
+
+	swapRemote(&remote{
+		endpoint: conf.LogEndpoint,
+		client:   httpclient.New(func() *tls.Config { return tlsConf }),
+	})
+	return nil
+}
+
+// swapRemote replaces the remote atomically, entries already being sent finish on the old client
+func swapRemote(next *remote) {
+	previous := logger.remote.Swap(next)
+	if previous != nil {
+		previous.client.CloseIdleConnections()
+	}
+}
+
+func loadLogControl() error {
+	conf := logger.conf.Load()
+	data, err := os.ReadFile(conf.LogControlFile)
+	if err != nil {
+		return fmt.Errorf("could not read LogControlFile %q: %w", conf.LogControlFile, err)
 	}
 
 	var logControls []logControl
 
 	if err := json.Unmarshal(data, &logControls); err != nil {
-		logger.logrus.Error(err)
-		logger.logrus.Warn("Could not parse LogControlFile, setting level to INFO")
-		return
+		return fmt.Errorf("could not parse LogControlFile %q: %w", conf.LogControlFile, err)
 	}
 
 	for _, item := range logControls {
-		if item.Container == logger.conf.ContainerName {
-			switch item.Severity {
-			case "critical":
-				SetLevel(FatalLevel)
-			case "error":
-				SetLevel(ErrorLevel)
-			case "warning":
-				SetLevel(WarningLevel)
-			case "info":
-				SetLevel(InfoLevel)
-			case "debug":
-				SetLevel(DebugLevel)
+		if item.Container == conf.ContainerName {
+			for _, severity := range severities {
+				if item.Severity == severity.name {
+					changeLevel(severity.level)
+				}
 			}
 			break
 		}
 	}
+	return nil
+}
+
+// changeLevel sets level and logs the change, at the more verbose of the two levels
+// so the message is not filtered out by both. The message is written to stdout under controlMu,
+// in order with the change, and queued for LOG_ENDPOINT once controlMu is released as queuing
+// may wait for room.
+func changeLevel(level logrus.Level) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:]) // skips runtime.Callers and changeLevel
+
+	logger.controlMu.Lock()
+	previous := Level()
+	if level == previous {
+		logger.controlMu.Unlock()
+		return
+	}
+	msg := "Log level changed from " + Severity(previous) + " to " + Severity(level)
+	msgLevel := max(level, previous)
+	if level > previous {
+		SetLevel(level)
+	}
+	logger.logrus.Log(msgLevel, msg)
+	if level < previous {
+		SetLevel(level)
+	}
+	logger.controlMu.Unlock()
+
+	dispatch(msg, msgLevel, std, pcs[0])
+}
+
+// WatchLogControl applies the log control file again whenever it changes, until ctx is cancelled.
+// A Kubernetes ConfigMap update is noticed within interval, an interval of 0 does not watch the file.
+// The file is applied once more when watching starts, so a change made since Init is not missed.
+// The path is read again on every poll, a file configured by Reload is watched from then on.
+// The level is kept when the changed file cannot be read.
+func WatchLogControl(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	apply := func() {
+		if err := loadLogControl(); err != nil {
+			WithError(err).Error("Could not apply the changed LogControlFile, keeping level " + Severity(Level()))
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var w *watcher.Watcher
+	path := ""
+	for {
+		if current := logger.conf.Load().LogControlFile; w == nil || current != path {
+			path = current
+			w = watcher.New(interval, apply, path)
+			if path != "" {
+				apply()
+			}
+		} else if path != "" && w.Poll() {
+			apply()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 // SetLevel Set Log Level
 func SetLevel(level logrus.Level) {
-	logger.level = level
+	logger.level.Store(uint32(level))
 	logger.logrus.SetLevel(level)
+	if h := hooks.Load(); h != nil && h.OnLevelChange != nil {
+		h.OnLevelChange(Severity(level))
+	}
+}
+
+// Level Returns the current log level
+func Level() logrus.Level {
+	return logrus.Level(logger.level.Load())
 }
 
 // SetOutput Set Logger Output
@@ -113,61 +299,77 @@ func SetOutput(writer io.Writer) {
 
 // Error Log at Error level
 func Error(msg string) {
-	if logger.level >= ErrorLevel {
-		logger.logrus.Error(msg)
-		dispatch(msg, ErrorLevel)
-	}
+	std.log(ErrorLevel, msg)
 }
 
 // Warning Log at Warning level
 func Warning(msg string) {
-	if logger.level >= WarningLevel {
-		logger.logrus.Warning(msg)
-		dispatch(msg, WarningLevel)
-	}
+	std.log(WarningLevel, msg)
 }
 
 // Info Log at Info level
 func Info(msg string) {
-	if logger.level >= InfoLevel {
-		logger.logrus.Info(msg)
-		dispatch(msg, InfoLevel)
-	}
+	std.log(InfoLevel, msg)
 }
 
 // Debug Log at Debug level
 func Debug(msg string) {
-	if logger.level >= DebugLevel {
-		logger.logrus.Debug(msg)
-		dispatch(msg, DebugLevel)
+	std.log(DebugLevel, msg)
+}
+
+// HTTPClient returns the mTLS client used to send entries to LOG_ENDPOINT, or nil when remote logging is off
+func HTTPClient() *http.Client {
+	remote := logger.remote.Load()
+	if remote == nil {
+		return nil
 	}
+	return remote.client.HTTPClient()
 }
 
-func dispatch(msg string, level logrus.Level) {
-	if logger.tlsConf == nil {
+// Flush Send the queued remote log entries without waiting for a full batch, and wait for them
+// to be sent, or for ctx to expire
+func Flush(ctx context.Context) error {
+	return entries.flush(ctx)
+}
+
+// dispatch queues the entry for LOG_ENDPOINT, it only waits when the queue is full and
+// LOG_DROP_POLICY is block
+func dispatch(msg string, level logrus.Level, e *Entry, pc uintptr) {
+	if logger.remote.Load() == nil {
 		return
 	}
 
-	entry := &logEntry{time.Now().Format(time.RFC3339), "0.0.1", msg, "rapp-eric-oss-hello-world-go-app", level.String()}
-	entryJSON, _ := json.Marshal(entry)
-	logger.wg.Wait()
-	logger.wg.Add(1)
-	go func() {
-		defer logger.wg.Done()
-		request, err := http.NewRequestWithContext(context.Background(), "POST",
-			"https://"+logger.conf.LogEndpoint, bytes.NewBuffer(entryJSON))
-		if err != nil {
-			logger.logrus.Error("Request failed for mTLS logging")
-			return
-		}
-
-		request.Header.Set("Content-Type", "application/json")
+	entryJSON, _ := json.Marshal(newLogEntry(time.Now(), msg, level, e, pc))
+	entries.enqueue(entryJSON)
+}
 
-		resp, err := logger.client.Do(request)
-		if err != nil {
-			logger.logrus.Error("Request failed for mTLS logging")
-			return
+// newLogEntry builds the remote log entry of msg, logged with e by the code at pc
+func newLogEntry(at time.Time, msg string, level logrus.Level, e *Entry, pc uintptr) *logEntry {
+	conf := logger.conf.Load()
+	entry := &logEntry{
+		Version:   SchemaVersion,
+		Timestamp: at.Format(timestampFormat),
+		Severity:  Severity(level),
+		ServiceID: conf.LogServiceID,
+		Message:   msg,
+		Metadata: entryMetadata{
+			AppVersion:    conf.AppVersion,
+			PodName:       conf.PodName,
+			PodUID:        conf.PodUID,
+			Namespace:     conf.Namespace,
+			NodeName:      conf.NodeName,
+			ContainerName: conf.ContainerName,
+		},
+		Facility:  e.facility,
+		Subject:   e.subject,
+		ExtraData: extraData(e.fields),
+	}
+	if pc != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		entry.Metadata.Function = frame.Function
+		if frame.File != "" {
+			entry.Metadata.Caller = filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
 		}
-		defer resp.Body.Close() //nolint:errcheck //error has no impact
-	}()
+	}
+	return entry
 }
@@ -1,17 +1,25 @@
 package logging
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"eric-oss-hello-world-go-app/src/internal/configuration"
 	"fmt"
 	"io"
+	"log/slog"
 	"math/big"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -37,7 +45,7 @@ func createTestFile(t *testing.T, fileName, fileContent string) {
 func TestInitWithoutLogCtrl(t *testing.T) {
 	Init()
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 }
 
@@ -49,7 +57,7 @@ func TestInitWithLogCtrl(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, DebugLevel)
+	assert.Equal(t, Level(), DebugLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), DebugLevel)
 }
 
@@ -61,7 +69,7 @@ func TestInitWithInfoLevel(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 }
 
@@ -73,7 +81,7 @@ func TestInitWithWarningLevel(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, WarningLevel)
+	assert.Equal(t, Level(), WarningLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), WarningLevel)
 }
 
@@ -85,7 +93,7 @@ func TestInitWithErrorLevel(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, ErrorLevel)
+	assert.Equal(t, Level(), ErrorLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), ErrorLevel)
 }
 
@@ -97,7 +105,7 @@ func TestInitWithFatalLevel(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, FatalLevel)
+	assert.Equal(t, Level(), FatalLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), FatalLevel)
 }
 
@@ -109,25 +117,267 @@ func TestInitWithInvalidLogCtrl(t *testing.T) {
 	Init()
 	assert.True(t, true)
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 }
 
+func TestReloadAppliesNewLogCtrl(t *testing.T) {
+	createTestFile(t, "logcontrol.json", "[{\"severity\": \"error\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]")
+	t.Setenv("LOG_CTRL_FILE", "logcontrol.json")
+	t.Setenv("CONTAINER_NAME", "rapp-eric-oss-hello-world-go-app")
+	configuration.ReloadAppConfig()
+	Init()
+	assert.Equal(t, Level(), ErrorLevel)
+
+	err := os.WriteFile("logcontrol.json", []byte("[{\"severity\": \"debug\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]"), 0o600)
+	assert.Nil(t, err)
+	assert.Nil(t, Reload())
+	assert.Equal(t, Level(), DebugLevel)
+	assert.Equal(t, logger.logrus.GetLevel(), DebugLevel)
+}
+
+func TestReloadKeepsLevelWithInvalidLogCtrl(t *testing.T) {
+	createTestFile(t, "logcontrol.json", "[{\"severity\": \"warning\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]")
+	t.Setenv("LOG_CTRL_FILE", "logcontrol.json")
+	t.Setenv("CONTAINER_NAME", "rapp-eric-oss-hello-world-go-app")
+	configuration.ReloadAppConfig()
+	Init()
+
+	err := os.WriteFile("logcontrol.json", []byte("[][]"), 0o600)
+	assert.Nil(t, err)
+	err = Reload()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not parse LogControlFile")
+	assert.Equal(t, Level(), WarningLevel)
+}
+
+func TestReloadKeepsRemoteWithMissingCerts(t *testing.T) {
+	current := &remote{endpoint: "log.test"}
+	logger.remote.Store(current)
+	t.Cleanup(func() { logger.remote.Store(nil) })
+
+	t.Setenv("LOG_ENDPOINT", "log.test")
+	configuration.ReloadAppConfig()
+	err := Reload()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not load mTLS certificates")
+	assert.Same(t, current, logger.remote.Load())
+}
+
+// syncBuffer is a bytes.Buffer the watcher goroutine can log to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// swapLogControl writes a new version of the log control file and re-points the ..data symlink to it,
+// as Kubernetes does when a ConfigMap changes
+func swapLogControl(t *testing.T, dir, version, content string) {
+	t.Helper()
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, version), 0o700))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, version, "logcontrol.json"), []byte(content), 0o600))
+	assert.Nil(t, os.Symlink(version, filepath.Join(dir, "..data_tmp")))
+	assert.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+}
+
+func TestWatchLogControlAppliesChanges(t *testing.T) {
+	dir := t.TempDir()
+	swapLogControl(t, dir, "v1", "[{\"severity\": \"info\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]")
+	assert.Nil(t, os.Symlink(filepath.Join("..data", "logcontrol.json"), filepath.Join(dir, "logcontrol.json")))
+	t.Setenv("LOG_CTRL_FILE", filepath.Join(dir, "logcontrol.json"))
+	t.Setenv("CONTAINER_NAME", "rapp-eric-oss-hello-world-go-app")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+	Init()
+	output := &syncBuffer{}
+	SetOutput(output)
+	var severities []string
+	var mu sync.Mutex
+	SetHooks(Hooks{OnLevelChange: func(severity string) {
+		mu.Lock()
+		defer mu.Unlock()
+		severities = append(severities, severity)
+	}})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchLogControl(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	swapLogControl(t, dir, "v2", "[{\"severity\": \"debug\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]")
+	assert.Eventually(t, func() bool { return Level() == DebugLevel }, 2*time.Second, 5*time.Millisecond)
+	assert.Contains(t, output.String(), "Log level changed from info to debug")
+
+	swapLogControl(t, dir, "v3", "[][]")
+	assert.Eventually(t, func() bool { return strings.Contains(output.String(), "could not parse LogControlFile") },
+		2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, DebugLevel, Level(), "The level should be kept when the file is invalid")
+
+	swapLogControl(t, dir, "v4", "[{\"severity\": \"error\",\"container\": \"rapp-eric-oss-hello-world-go-app\"}]")
+	assert.Eventually(t, func() bool { return Level() == ErrorLevel }, 2*time.Second, 5*time.Millisecond)
+	assert.Contains(t, output.String(), "Log level changed from debug to error",
+		"Lowering the level should be logged before it applies")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"debug", "error"}, severities)
+}
+
+func TestWatchLogControlFollowsReloadedPath(t *testing.T) {
+	first := filepath.Join(t.TempDir(), "logcontrol.json")
+	second := filepath.Join(t.TempDir(), "logcontrol.json")
+	assert.Nil(t, os.WriteFile(first, []byte("[{\"severity\": \"info\",\"container\": \"app\"}]"), 0o600))
+	assert.Nil(t, os.WriteFile(second, []byte("[{\"severity\": \"info\",\"container\": \"app\"}]"), 0o600))
+	t.Setenv("LOG_CTRL_FILE", first)
+	t.Setenv("CONTAINER_NAME", "app")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+	Init()
+	SetOutput(&syncBuffer{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchLogControl(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	t.Setenv("LOG_CTRL_FILE", second)
+	configuration.ReloadAppConfig()
+	assert.Nil(t, Reload())
+	assert.Nil(t, os.WriteFile(second, []byte("[{\"severity\": \"debug\",\"container\": \"app\"}]"), 0o600))
+
+	assert.Eventually(t, func() bool { return Level() == DebugLevel }, 2*time.Second, 5*time.Millisecond,
+		"The file configured by Reload should be watched")
+}
+
+func TestWatchLogControlWithoutInterval(t *testing.T) {
+	Init()
+	done := make(chan struct{})
+	go func() {
+		WatchLogControl(context.Background(), 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WatchLogControl should return when the interval is 0")
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	assert.Equal(t, []string{"critical", "error", "warning", "info", "debug"}, Severities)
+	assert.Equal(t, "critical", Severity(FatalLevel))
+	assert.Equal(t, "warning", Severity(WarningLevel))
+	assert.Equal(t, "panic", Severity(PanicLevel))
+}
+
+func TestLogEntrySchema(t *testing.T) {
+	Init()
+	logger.conf.Store(&configuration.Config{
+		LogServiceID:  "rapp-test",
+		AppVersion:    "4.1.0",
+		PodName:       "hello-7d9f",
+		PodUID:        "0b7e3c1a",
+		Namespace:     "apps",
+		NodeName:      "worker-1",
+		ContainerName: "hello",
+	})
+	at := time.Date(2026, 3, 4, 5, 6, 7, 8_000_000, time.UTC)
+	e := WithFacility(FacilitySecurity).WithSubject("alice").WithFields(Fields{"attempt": 2})
+
+	entryJSON, err := json.Marshal(newLogEntry(at, "login failed", FatalLevel, e, 0))
+
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"version": "1.0.0",
+		"timestamp": "2026-03-04T05:06:07.008Z",
+		"severity": "critical",
+		"service_id": "rapp-test",
+		"message": "login failed",
+		"metadata": {
+			"application_version": "4.1.0",
+			"pod_name": "hello-7d9f",
+			"pod_uid": "0b7e3c1a",
+			"namespace": "apps",
+			"node_name": "worker-1",
+			"container_name": "hello"
+		},
+		"facility": "security/authorization messages",
+		"subject": "alice",
+		"extra_data": {"attempt": 2}
+	}`, string(entryJSON))
+
+	logger.conf.Store(&configuration.Config{LogServiceID: "rapp-test"})
+	entryJSON, err = json.Marshal(newLogEntry(at, "hello", InfoLevel, std, 0))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+		"version": "1.0.0",
+		"timestamp": "2026-03-04T05:06:07.008Z",
+		"severity": "info",
+		"service_id": "rapp-test",
+		"message": "hello",
+		"metadata": {}
+	}`, string(entryJSON), "Unknown optional fields should be left out")
+}
+
+func TestDispatchReportsCaller(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+
+	_, _, line, _ := runtime.Caller(0)
+	Info("plain")
+	WithField("key", "value").Info("with fields")
+	slog.New(NewSlogHandler()).Info("from slog")
+
+	assert.Nil(t, Flush(context.Background()))
+	endpoint.mu.Lock()
+	defer endpoint.mu.Unlock()
+	assert.Len(t, endpoint.entries, 3)
+	for i, entry := range endpoint.entries {
+		assert.Equal(t, "eric-oss-hello-world-go-app/src/internal/logging.TestDispatchReportsCaller",
+			entry.Metadata.Function, entry.Message)
+		assert.Equal(t, "logging_test.go:"+strconv.Itoa(line+1+i), entry.Metadata.Caller, entry.Message)
+	}
+}
+
 func TestSetLevel(t *testing.T) {
 	Init()
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 
 	SetLevel(DebugLevel)
-	assert.Equal(t, logger.level, DebugLevel)
+	assert.Equal(t, Level(), DebugLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), DebugLevel)
 }
 
 func TestLogOnLowestLevel(t *testing.T) {
 	Init()
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 
 	file, err := os.OpenFile(logOutputFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
@@ -160,7 +410,7 @@ func TestLogOnLowestLevel(t *testing.T) {
 func TestLogOnHighestLevel(t *testing.T) {
 	Init()
 	assert.NotNil(t, logger.logrus)
-	assert.Equal(t, logger.level, InfoLevel)
+	assert.Equal(t, Level(), InfoLevel)
 	assert.Equal(t, logger.logrus.GetLevel(), InfoLevel)
 
 	file, err := os.OpenFile(logOutputFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
@@ -211,6 +461,31 @@ func TestDispatch(t *testing.T) {
 	})
 }
 
+func TestFlushWithoutPendingEntries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// the entries of the previous tests are drained first, the shipper reads logger while sending them
+	assert.Nil(t, Flush(ctx))
+	Init()
+	assert.Nil(t, Flush(ctx))
+}
+
+func TestFlushWithPendingEntries(t *testing.T) {
+	Init()
+	previous := entries
+	entries = newShipper()
+	entries.start.Do(func() {}) // no worker yet, the entry stays queued
+	t.Cleanup(func() { entries = previous })
+
+	entries.enqueue([]byte(`{}`))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, Flush(ctx), context.DeadlineExceeded)
+
+	go entries.run()
+	assert.Nil(t, Flush(context.Background()))
+}
+
 func generateCACert() error {
 	file, err := os.OpenFile("cacert.crt", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
 	if err != nil {
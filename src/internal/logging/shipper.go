@@ -0,0 +1,373 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+)
+
+// Outcomes of remote log entries, reported to Hooks.OnEntries
+const (
+	// Queued the entry was queued to be sent
+	Queued = "queued"
+	// Sent the entry was accepted by LOG_ENDPOINT
+	Sent = "sent"
+	// Failed sending the entry failed and it could not be spooled, it is not retried
+	Failed = "failed"
+	// Spooled sending the entry failed, it was kept in LOG_SPOOL_DIR to be sent again
+	Spooled = "spooled"
+	// Dropped the entry was dropped because the queue or the spool was full or remote logging was turned off
+	Dropped = "dropped"
+)
+
+const (
+	defaultQueueSize     = 1000
+	defaultBatchSize     = 100
+	defaultBatchInterval = time.Second
+	defaultSpoolMaxSize  = 16 << 20
+
+	breakerThreshold   = 3
+	breakerOpenTimeout = 30 * time.Second
+)
+
+// Hooks are notified of what happens to remote log entries and of level changes, the server uses them
+// to export metrics
+type Hooks struct {
+	// OnEntries is called with the outcome of n entries, see Queued, Sent, Failed and Dropped
+	OnEntries func(outcome string, n int)
+	// OnSpool is called with the number of entries and bytes in LOG_SPOOL_DIR whenever they change
+	OnSpool func(entries int, bytes int64)
+	// OnStateChange is called when the circuit breaker of LOG_ENDPOINT changes state
+	OnStateChange func(state resilience.State)
+	// OnLevelChange is called with the severity of the new log level, see Severities
+	OnLevelChange func(severity string)
+}
+
+var hooks atomic.Pointer[Hooks]
+
+// SetHooks replaces the hooks called for remote log entries
+func SetHooks(h Hooks) {
+	hooks.Store(&h)
+}
+
+// report calls the OnEntries hook of to
+func report(to *atomic.Pointer[Hooks], outcome string, n int) {
+	if h := to.Load(); h != nil && h.OnEntries != nil && n > 0 {
+		h.OnEntries(outcome, n)
+	}
+}
+
+// shipper sends the remote log entries in batches from a background goroutine, so logging never
+// waits for LOG_ENDPOINT unless the queue is full and LOG_DROP_POLICY is block. The batches
+// LOG_ENDPOINT does not accept are spooled to disk when LOG_SPOOL_DIR is set.
+type shipper struct {
+	start sync.Once
+	// hooks are those of the package, a test gives its shipper its own to tell its outcomes apart
+	hooks   *atomic.Pointer[Hooks]
+	breaker *resilience.Breaker
+	// replayBackoff is the wait between two failed attempts to send a spooled batch
+	replayBackoff resilience.RetryPolicy
+
+	mu            sync.Mutex
+	queueSize     int
+	batchSize     int
+	batchInterval time.Duration
+	dropPolicy    string
+	queue         [][]byte
+	// pending counts the queued entries and those being sent, idle is closed when it drops to 0
+	pending int
+	idle    chan struct{}
+	// flushing makes the queued entries be sent without waiting for a full batch
+	flushing bool
+	wake     chan struct{}
+	space    *sync.Cond
+	spool    *spool
+}
+
+func newShipper() *shipper {
+	s := &shipper{
+		hooks:         &hooks,
+		breaker:       resilience.NewBreaker(breakerThreshold, breakerOpenTimeout),
+		replayBackoff: resilience.RetryPolicy{InitialBackoff: time.Second, MaxBackoff: time.Minute},
+		wake:          make(chan struct{}, 1),
+		idle:          make(chan struct{}),
+	}
+	s.breaker.OnStateChange = func(state resilience.State) {
+		logger.logrus.Warn("Circuit breaker for LOG_ENDPOINT is now " + state.String())
+		if h := s.hooks.Load(); h != nil && h.OnStateChange != nil {
+			h.OnStateChange(state)
+		}
+	}
+	close(s.idle)
+	s.space = sync.NewCond(&s.mu)
+	s.configure(&configuration.Config{})
+	return s
+}
+
+// configure applies the queue settings of conf, entries beyond a smaller queue size are kept
+func (s *shipper) configure(conf *configuration.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queueSize = positiveOr(conf.LogQueueSize, defaultQueueSize)
+	s.batchSize = positiveOr(conf.LogBatchSize, defaultBatchSize)
+	s.batchInterval = conf.LogBatchInterval
+	if s.batchInterval <= 0 {
+		s.batchInterval = defaultBatchInterval
+	}
+	s.dropPolicy = conf.LogDropPolicy
+	s.space.Broadcast()
+}
+
+// useSpool spools the failed batches in dir, or stops spooling when dir is empty. The batches left in
+// dir by a previous run are sent again, those of a previous dir stay where they are.
+func (s *shipper) useSpool(dir string, maxSize int64) error {
+	if maxSize <= 0 {
+		maxSize = defaultSpoolMaxSize
+	}
+	s.mu.Lock()
+	current := s.spool
+	s.mu.Unlock()
+	if current != nil && current.dir == dir {
+		current.resize(maxSize)
+		return nil
+	}
+
+	var next *spool
+	if dir != "" {
+		var err error
+		if next, err = openSpool(dir, maxSize, s.hooks); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.spool = next
+	s.mu.Unlock()
+	if current != nil {
+		current.close()
+	}
+	if next != nil {
+		go next.replay(s.resend, s.replayBackoff)
+	}
+	return nil
+}
+
+func positiveOr(value, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// enqueue adds entry to the queue, applying the drop policy when it is full
+func (s *shipper) enqueue(entry []byte) {
+	s.start.Do(func() { go s.run() })
+
+	s.mu.Lock()
+	for len(s.queue) >= s.queueSize && s.dropPolicy == configuration.LogBlock {
+		s.space.Wait()
+	}
+	if len(s.queue) >= s.queueSize {
+		if s.dropPolicy == configuration.LogDropNewest {
+			s.mu.Unlock()
+			s.report(Dropped, 1)
+			return
+		}
+		s.queue = s.queue[1:]
+		s.finish(1)
+		defer s.report(Dropped, 1)
+	}
+	s.queue = append(s.queue, entry)
+	if s.pending == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.pending++
+	// the first entry starts the batch interval, a full batch is sent right away
+	wake := len(s.queue) == 1 || len(s.queue) >= s.batchSize
+	s.mu.Unlock()
+
+	s.report(Queued, 1)
+	if wake {
+		s.signal()
+	}
+}
+
+// report calls the OnEntries hook of the shipper
+func (s *shipper) report(outcome string, n int) {
+	report(s.hooks, outcome, n)
+}
+
+// finish marks n entries as sent, failed or dropped, must be called with mu held
+func (s *shipper) finish(n int) {
+	s.pending -= n
+	if s.pending == 0 {
+		close(s.idle)
+	}
+}
+
+func (s *shipper) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush sends the queued entries without waiting for a full batch, and waits until they are
+// sent or ctx is done
+func (s *shipper) flush(ctx context.Context) error {
+	s.mu.Lock()
+	if s.pending == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	idle := s.idle
+	s.flushing = true
+	s.mu.Unlock()
+	s.signal()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *shipper) run() {
+	for {
+		batch := s.next()
+		s.send(batch)
+
+		s.mu.Lock()
+		s.finish(len(batch))
+		s.mu.Unlock()
+	}
+}
+
+// next waits for a full batch, for the batch interval to pass since an entry was queued,
+// or for a flush, and takes the batch off the queue
+func (s *shipper) next() [][]byte {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		s.mu.Lock()
+		if len(s.queue) >= s.batchSize || (len(s.queue) > 0 && s.flushing) {
+			batch := s.take()
+			s.mu.Unlock()
+			return batch
+		}
+		if len(s.queue) > 0 && timer == nil {
+			timer = time.NewTimer(s.batchInterval)
+		}
+		s.mu.Unlock()
+
+		var timeout <-chan time.Time
+		if timer != nil {
+			timeout = timer.C
+		}
+		select {
+		case <-s.wake:
+		case <-timeout:
+			timer = nil
+			s.mu.Lock()
+			batch := s.take()
+			s.mu.Unlock()
+			if len(batch) > 0 {
+				return batch
+			}
+		}
+	}
+}
+
+// take removes up to a batch of entries from the queue, must be called with mu held
+func (s *shipper) take() [][]byte {
+	n := len(s.queue)
+	if n > s.batchSize {
+		n = s.batchSize
+	}
+	batch := s.queue[:n:n]
+	s.queue = s.queue[n:]
+	if len(s.queue) == 0 {
+		s.flushing = false
+	}
+	s.space.Broadcast()
+	return batch
+}
+
+// send posts batch to LOG_ENDPOINT, spooling it when that fails. While the spool holds entries the
+// batch goes after them, so the entries reach LOG_ENDPOINT in order.
+func (s *shipper) send(batch [][]byte) {
+	remote := logger.remote.Load()
+	if remote == nil {
+		s.report(Dropped, len(batch))
+		return
+	}
+
+	s.mu.Lock()
+	spool := s.spool
+	s.mu.Unlock()
+	if spool == nil || spool.depth() == 0 {
+		err := s.breaker.Execute(func() error { return post(remote, batch) })
+		if err == nil {
+			s.report(Sent, len(batch))
+			return
+		}
+		if !errors.Is(err, resilience.ErrCircuitOpen) {
+			logger.logrus.Error(err)
+		}
+		if spool == nil {
+			s.report(Failed, len(batch))
+			return
+		}
+	}
+
+	if err := spool.write(batch); err != nil {
+		logger.logrus.Error(err)
+		s.report(Failed, len(batch))
+		return
+	}
+	s.report(Spooled, len(batch))
+}
+
+// resend posts a spooled batch, the circuit breaker stops it while LOG_ENDPOINT is failing
+func (s *shipper) resend(batch [][]byte) error {
+	remote := logger.remote.Load()
+	if remote == nil {
+		return errors.New("remote logging is turned off")
+	}
+	return s.breaker.Execute(func() error { return post(remote, batch) })
+}
+
+// post sends batch to LOG_ENDPOINT as a JSON array
+func post(remote *remote, batch [][]byte) error {
+	body := append([]byte("["), bytes.Join(batch, []byte(","))...)
+	body = append(body, ']')
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+		"https://"+remote.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Request failed for mTLS logging: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := remote.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("Request failed for mTLS logging: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck //error has no impact
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("Request failed for mTLS logging with status " + strconv.Itoa(resp.StatusCode))
+	}
+	return nil
+}
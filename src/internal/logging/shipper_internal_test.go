@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/httpclient"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logEndpoint records the messages of each batch it receives, and the entries themselves
+type logEndpoint struct {
+	mu      sync.Mutex
+	batches [][]string
+	entries []logEntry
+	status  int
+}
+
+func (e *logEndpoint) received() [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([][]string(nil), e.batches...)
+}
+
+// useLogEndpoint points the remote logging to a local TLS server, the status it answers can be changed
+func useLogEndpoint(t *testing.T) *logEndpoint {
+	t.Helper()
+	Init()
+	endpoint := &logEndpoint{status: http.StatusOK}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var batch []logEntry
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&batch))
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		endpoint.mu.Lock()
+		defer endpoint.mu.Unlock()
+		var messages []string
+		for _, entry := range batch {
+			messages = append(messages, entry.Message)
+		}
+		endpoint.batches = append(endpoint.batches, messages)
+		endpoint.entries = append(endpoint.entries, batch...)
+		rw.WriteHeader(endpoint.status)
+	}))
+	t.Cleanup(server.Close)
+	tlsConfig := &tls.Config{
+		RootCAs:    server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	logger.remote.Store(&remote{
+		endpoint: server.Listener.Addr().String(),
+		client:   httpclient.New(func() *tls.Config { return tlsConfig }),
+	})
+	t.Cleanup(func() { logger.remote.Store(nil) })
+	return endpoint
+}
+
+// countOutcomes records the outcomes reported to hooks
+func countOutcomes(hooks *atomic.Pointer[Hooks]) func(outcome string) int {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	hooks.Store(&Hooks{OnEntries: func(outcome string, n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts[outcome] += n
+	}})
+	return func(outcome string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[outcome]
+	}
+}
+
+// newTestShipper returns a shipper with hooks of its own, so the outcomes of other tests are not counted
+func newTestShipper(conf *configuration.Config) *shipper {
+	s := newShipper()
+	s.hooks = &atomic.Pointer[Hooks]{}
+	s.configure(conf)
+	return s
+}
+
+func entry(msg string) []byte {
+	data, _ := json.Marshal(&logEntry{Message: msg})
+	return data
+}
+
+func TestShipperSendsFullBatches(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	s := newTestShipper(&configuration.Config{LogBatchSize: 3, LogBatchInterval: time.Hour})
+	outcomes := countOutcomes(s.hooks)
+
+	for i := 1; i <= 4; i++ {
+		s.enqueue(entry("entry " + strconv.Itoa(i)))
+	}
+
+	assert.Eventually(t, func() bool { return len(endpoint.received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, [][]string{{"entry 1", "entry 2", "entry 3"}}, endpoint.received())
+	assert.Nil(t, s.flush(context.Background()))
+	assert.Equal(t, [][]string{{"entry 1", "entry 2", "entry 3"}, {"entry 4"}}, endpoint.received(),
+		"Flush should send the partial batch")
+	assert.Equal(t, 4, outcomes(Queued))
+	assert.Equal(t, 4, outcomes(Sent))
+}
+
+func TestShipperSendsAfterBatchInterval(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	s := newTestShipper(&configuration.Config{LogBatchSize: 100, LogBatchInterval: 20 * time.Millisecond})
+
+	s.enqueue(entry("alone"))
+
+	assert.Eventually(t, func() bool { return len(endpoint.received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, [][]string{{"alone"}}, endpoint.received())
+}
+
+func TestShipperCountsFailedBatches(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	endpoint.status = http.StatusServiceUnavailable
+	s := newTestShipper(&configuration.Config{LogBatchSize: 2})
+	outcomes := countOutcomes(s.hooks)
+
+	s.enqueue(entry("first"))
+	s.enqueue(entry("second"))
+
+	assert.Nil(t, s.flush(context.Background()))
+	assert.Equal(t, 2, outcomes(Failed))
+	assert.Equal(t, 0, outcomes(Sent))
+}
+
+func TestShipperDropPolicies(t *testing.T) {
+	Init()
+	for policy, kept := range map[string][]string{
+		configuration.LogDropOldest: {"second", "third"},
+		configuration.LogDropNewest: {"first", "second"},
+	} {
+		s := newTestShipper(&configuration.Config{LogQueueSize: 2, LogDropPolicy: policy})
+		outcomes := countOutcomes(s.hooks)
+		s.start.Do(func() {}) // no worker, the queue fills up
+
+		for _, msg := range []string{"first", "second", "third"} {
+			s.enqueue(entry(msg))
+		}
+
+		var queued []string
+		for _, data := range s.queue {
+			var e logEntry
+			assert.Nil(t, json.Unmarshal(data, &e))
+			queued = append(queued, e.Message)
+		}
+		assert.Equal(t, kept, queued, policy)
+		assert.Equal(t, 1, outcomes(Dropped), policy)
+		assert.Equal(t, 2, s.pending, policy)
+	}
+}
+
+func TestShipperBlocksWhenFull(t *testing.T) {
+	Init()
+	s := newTestShipper(&configuration.Config{LogQueueSize: 1, LogDropPolicy: configuration.LogBlock})
+	s.start.Do(func() {}) // no worker, the queue fills up
+	s.enqueue(entry("first"))
+
+	done := make(chan struct{})
+	go func() {
+		s.enqueue(entry("second"))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("enqueue should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	s.mu.Lock()
+	batch := s.take()
+	s.mu.Unlock()
+	assert.Len(t, batch, 1)
+	<-done
+}
+
+func TestShipperDropsWithoutRemote(t *testing.T) {
+	Init()
+	logger.remote.Store(nil)
+	s := newTestShipper(&configuration.Config{})
+	outcomes := countOutcomes(s.hooks)
+
+	s.enqueue(entry("nowhere"))
+
+	assert.Nil(t, s.flush(context.Background()))
+	assert.Equal(t, 1, outcomes(Dropped))
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// slogHandler is a slog.Handler logging through this package, so the records of code using log/slog
+// reach stdout and LOG_ENDPOINT like the others and follow the log control file
+type slogHandler struct {
+	fields Fields
+	// group prefixes the keys of the attributes added from now on, "" outside of any group
+	group string
+}
+
+// NewSlogHandler Create a slog.Handler logging through this package, the attributes of a record become
+// its fields, prefixed by their groups as in "group.key", and the fields of its context are added
+func NewSlogHandler() slog.Handler {
+	return &slogHandler{}
+}
+
+// slogLevel maps a slog level to the closest level of this package
+func slogLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarningLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	default:
+		return DebugLevel
+	}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return Level() >= slogLevel(level)
+}
+
+func (h *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	level := slogLevel(record.Level)
+	if Level() < level {
+		return nil
+	}
+	fields := Fields{}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(fields, h.group, attr)
+		return true
+	})
+	entry := &Entry{fields: merge(merge(fieldsFromContext(ctx), h.fields), fields)}
+	entry.output(level, record.Message, record.PC)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := Fields{}
+	for _, attr := range attrs {
+		addAttr(fields, h.group, attr)
+	}
+	return &slogHandler{fields: merge(h.fields, fields), group: h.group}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{fields: h.fields, group: h.group + name + "."}
+}
+
+// addAttr adds attr to fields under prefix, flattening groups and dropping empty attributes as slog does
+func addAttr(fields Fields, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			addAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+	fields[prefix+attr.Key] = attr.Value.Any()
+}
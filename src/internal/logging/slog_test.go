@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogHandlerLogsAttributes(t *testing.T) {
+	output := captureOutput(t)
+	logger := slog.New(NewSlogHandler()).With("component", "client").WithGroup("req")
+	ctx := NewContext(context.Background(), Fields{"route": "/hello"})
+
+	logger.InfoContext(ctx, "sent", "id", 7, slog.Group("peer", "host", "iam"), slog.Group("", "inline", true))
+
+	line := output.String()
+	assert.Contains(t, line, "msg=sent")
+	assert.Contains(t, line, "level=info")
+	assert.Contains(t, line, "component=client")
+	assert.Contains(t, line, "req.id=7")
+	assert.Contains(t, line, "req.peer.host=iam")
+	assert.Contains(t, line, "req.inline=true")
+	assert.Contains(t, line, "route=/hello")
+}
+
+func TestSlogHandlerFollowsLevel(t *testing.T) {
+	output := captureOutput(t)
+	SetLevel(WarningLevel)
+	logger := slog.New(NewSlogHandler())
+
+	assert.False(t, logger.Enabled(context.Background(), slog.LevelInfo))
+	assert.True(t, logger.Enabled(context.Background(), slog.LevelWarn))
+	logger.Info("hidden")
+	logger.Error("shown")
+
+	assert.NotContains(t, output.String(), "hidden")
+	assert.Contains(t, output.String(), "level=error msg=shown")
+}
+
+func TestSlogLevel(t *testing.T) {
+	for level, expected := range map[slog.Level]string{
+		slog.LevelError + 4: "error",
+		slog.LevelError:     "error",
+		slog.LevelWarn:      "warning",
+		slog.LevelInfo:      "info",
+		slog.LevelInfo + 1:  "info",
+		slog.LevelDebug:     "debug",
+		slog.LevelDebug - 4: "debug",
+	} {
+		assert.Equal(t, expected, Severity(slogLevel(level)), level.String())
+	}
+}
@@ -0,0 +1,254 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+)
+
+const spoolFileSuffix = ".log"
+
+// spoolFile is a batch kept on disk, one JSON entry per line
+type spoolFile struct {
+	name    string
+	size    int64
+	entries int
+}
+
+// spool keeps the batches LOG_ENDPOINT did not accept in LOG_SPOOL_DIR, one file per batch named by a
+// sequence number, and sends them again oldest first. Once the spool would exceed LOG_SPOOL_MAX_SIZE
+// the oldest batches are dropped.
+type spool struct {
+	dir     string
+	maxSize int64
+
+	hooks   *atomic.Pointer[Hooks]
+	mu      sync.Mutex
+	files   []spoolFile
+	size    int64
+	entries int
+	next    uint64
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+// openSpool Create the spool in dir, picking up the batches left by a previous run, and reporting to hooks.
+// The temporary files of a write the previous run did not complete are deleted.
+func openSpool(dir string, maxSize int64, hooks *atomic.Pointer[Hooks]) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create LOG_SPOOL_DIR %q: %w", dir, err)
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read LOG_SPOOL_DIR %q: %w", dir, err)
+	}
+
+	s := &spool{dir: dir, maxSize: maxSize, hooks: hooks, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	for _, dirEntry := range dirEntries {
+		if isPartialSpoolFile(dirEntry) {
+			if err := os.Remove(filepath.Join(dir, dirEntry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("could not remove partially spooled log entries: %w", err)
+			}
+			continue
+		}
+		sequence, ok := spoolSequence(dirEntry.Name())
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, dirEntry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read spooled log entries: %w", err)
+		}
+		file := spoolFile{name: dirEntry.Name(), size: int64(len(data)), entries: bytes.Count(data, []byte("\n"))}
+		s.files = append(s.files, file)
+		s.size += file.size
+		s.entries += file.entries
+		if sequence >= s.next {
+			s.next = sequence + 1
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	s.report()
+	return s, nil
+}
+
+// spoolSequence returns the sequence number of a spool file name
+func spoolSequence(name string) (uint64, bool) {
+	sequence, err := strconv.ParseUint(strings.TrimSuffix(name, spoolFileSuffix), 10, 64)
+	return sequence, err == nil && strings.HasSuffix(name, spoolFileSuffix)
+}
+
+// isPartialSpoolFile reports whether dirEntry is the temporary file of an incomplete write
+func isPartialSpoolFile(dirEntry os.DirEntry) bool {
+	name, temporary := strings.CutPrefix(dirEntry.Name(), ".")
+	_, ok := spoolSequence(name)
+	return temporary && ok && !dirEntry.IsDir()
+}
+
+// resize changes the size the spool is kept within, dropping the oldest batches right away when
+// the spool is now too large
+func (s *spool) resize(maxSize int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSize = maxSize
+	if err := s.trim(0); err != nil {
+		logger.logrus.Error(err.Error())
+	}
+}
+
+// depth Returns the number of spooled entries
+func (s *spool) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+// write keeps batch on disk, dropping the oldest batches to stay within the size of the spool
+func (s *spool) write(batch [][]byte) error {
+	data := append(bytes.Join(batch, []byte("\n")), '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int64(len(data)) > s.maxSize {
+		return fmt.Errorf("could not spool %d log entries, %d bytes exceed LOG_SPOOL_MAX_SIZE", len(batch), len(data))
+	}
+	if err := s.trim(int64(len(data))); err != nil {
+		return err
+	}
+
+	// written under a temporary name first, so a crash never leaves half a batch to replay
+	name := fmt.Sprintf("%020d%s", s.next, spoolFileSuffix)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("could not spool log entries: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("could not spool log entries: %w", err)
+	}
+	s.next++
+	file := spoolFile{name: name, size: int64(len(data)), entries: len(batch)}
+	s.files = append(s.files, file)
+	s.size += file.size
+	s.entries += file.entries
+	s.report()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// trim drops the oldest batches until incoming more bytes fit within the size of the spool, must be
+// called with mu held
+func (s *spool) trim(incoming int64) error {
+	for len(s.files) > 0 && s.size+incoming > s.maxSize {
+		oldest := s.files[0]
+		if err := os.Remove(filepath.Join(s.dir, oldest.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not drop spooled log entries: %w", err)
+		}
+		s.forget(oldest)
+		report(s.hooks, Dropped, oldest.entries)
+	}
+	return nil
+}
+
+// oldest Returns the oldest spooled batch, ok is false when the spool is empty
+func (s *spool) oldest() (file spoolFile, batch [][]byte, ok bool, err error) {
+	s.mu.Lock()
+	if len(s.files) == 0 {
+		s.mu.Unlock()
+		return spoolFile{}, nil, false, nil
+	}
+	file = s.files[0]
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(s.dir, file.name))
+	if err != nil {
+		return file, nil, true, err
+	}
+	return file, bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")), true, nil
+}
+
+// remove deletes a replayed batch and reports its entries with outcome. A batch trim dropped meanwhile
+// was already reported as Dropped, it is not reported again.
+func (s *spool) remove(file spoolFile, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 || s.files[0].name != file.name {
+		// already dropped to make room
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, file.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.logrus.Error("Could not remove replayed log entries: " + err.Error())
+	}
+	s.forget(file)
+	report(s.hooks, outcome, file.entries)
+}
+
+// forget drops the oldest file from the accounting, must be called with mu held
+func (s *spool) forget(file spoolFile) {
+	s.files = s.files[1:]
+	s.size -= file.size
+	s.entries -= file.entries
+	s.report()
+}
+
+// report publishes the depth of the spool, must be called with mu held
+func (s *spool) report() {
+	if h := s.hooks.Load(); h != nil && h.OnSpool != nil {
+		h.OnSpool(s.entries, s.size)
+	}
+}
+
+// replay sends the spooled batches oldest first until close is called, backing off while send fails
+func (s *spool) replay(send func(batch [][]byte) error, backoff resilience.RetryPolicy) {
+	failures := 0
+	for {
+		file, batch, ok, err := s.oldest()
+		switch {
+		case !ok:
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		case err != nil:
+			logger.logrus.Error("Could not read spooled log entries, dropping them: " + err.Error())
+			s.remove(file, Failed)
+			continue
+		}
+
+		if err := send(batch); err != nil {
+			failures++
+			timer := time.NewTimer(backoff.Backoff(failures))
+			select {
+			case <-timer.C:
+			case <-s.stop:
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		failures = 0
+		s.remove(file, Sent)
+	}
+}
+
+// close stops the replay, the spooled batches stay on disk
+func (s *spool) close() {
+	close(s.stop)
+}
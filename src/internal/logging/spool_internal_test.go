@@ -0,0 +1,195 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func batchOf(msgs ...string) [][]byte {
+	var batch [][]byte
+	for _, msg := range msgs {
+		batch = append(batch, entry(msg))
+	}
+	return batch
+}
+
+// spoolingShipper returns a shipper spooling in a temporary directory, which retries quickly
+func spoolingShipper(t *testing.T, dir string) *shipper {
+	t.Helper()
+	s := newTestShipper(&configuration.Config{LogBatchSize: 1})
+	s.breaker = resilience.NewBreaker(1, 20*time.Millisecond)
+	s.replayBackoff = resilience.RetryPolicy{InitialBackoff: 5 * time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	assert.Nil(t, s.useSpool(dir, 0))
+	t.Cleanup(func() { _ = s.useSpool("", 0) })
+	return s
+}
+
+func (e *logEndpoint) answer(status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+}
+
+func TestSpoolKeepsBatchesAcrossRestarts(t *testing.T) {
+	Init()
+	dir := t.TempDir()
+	s, err := openSpool(dir, 1<<10, &atomic.Pointer[Hooks]{})
+	assert.Nil(t, err)
+	assert.Nil(t, s.write(batchOf("first", "second")))
+	assert.Nil(t, s.write(batchOf("third")))
+
+	reopened, err := openSpool(dir, 1<<10, &atomic.Pointer[Hooks]{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, reopened.depth())
+	file, batch, ok, err := reopened.oldest()
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, batchOf("first", "second"), batch)
+
+	reopened.remove(file, Sent)
+	assert.Nil(t, reopened.write(batchOf("fourth")))
+	names, _ := filepath.Glob(filepath.Join(dir, "*"+spoolFileSuffix))
+	assert.Equal(t, []string{filepath.Join(dir, "00000000000000000001.log"), filepath.Join(dir, "00000000000000000002.log")},
+		names, "New batches should go after those of the previous run")
+}
+
+func TestSpoolDropsOldestBatchesWhenFull(t *testing.T) {
+	Init()
+	hooks := &atomic.Pointer[Hooks]{}
+	outcomes := countOutcomes(hooks)
+	size := int64(len(entry("first")) + 1)
+	s, err := openSpool(t.TempDir(), 2*size, hooks)
+	assert.Nil(t, err)
+	var depths []int
+	hooks.Store(&Hooks{OnEntries: hooks.Load().OnEntries, OnSpool: func(entries int, bytes int64) {
+		depths = append(depths, entries)
+	}})
+
+	for _, msg := range []string{"first", "secnd", "third"} {
+		assert.Nil(t, s.write(batchOf(msg)))
+	}
+
+	_, batch, _, _ := s.oldest()
+	assert.Equal(t, batchOf("secnd"), batch)
+	assert.Equal(t, 1, outcomes(Dropped))
+	assert.Equal(t, []int{1, 2, 1, 2}, depths)
+
+	err = s.write(batchOf("first", "secnd", "third"))
+	assert.NotNil(t, err, "A batch larger than the spool should be refused")
+	assert.Equal(t, 2, s.depth())
+}
+
+func TestSpoolShrinksWhenResized(t *testing.T) {
+	Init()
+	hooks := &atomic.Pointer[Hooks]{}
+	outcomes := countOutcomes(hooks)
+	size := int64(len(entry("first")) + 1)
+	s, err := openSpool(t.TempDir(), 3*size, hooks)
+	assert.Nil(t, err)
+	for _, msg := range []string{"first", "secnd", "third"} {
+		assert.Nil(t, s.write(batchOf(msg)))
+	}
+
+	s.resize(size)
+
+	assert.Equal(t, 1, s.depth(), "Lowering the size should drop the oldest batches at once")
+	assert.Equal(t, 2, outcomes(Dropped))
+	_, batch, _, _ := s.oldest()
+	assert.Equal(t, batchOf("third"), batch)
+}
+
+func TestSpoolDoesNotReportReplayedBatchDroppedMeanwhile(t *testing.T) {
+	Init()
+	hooks := &atomic.Pointer[Hooks]{}
+	outcomes := countOutcomes(hooks)
+	size := int64(len(entry("first")) + 1)
+	s, err := openSpool(t.TempDir(), 2*size, hooks)
+	assert.Nil(t, err)
+	assert.Nil(t, s.write(batchOf("first")))
+	assert.Nil(t, s.write(batchOf("secnd")))
+	replayed, _, _, _ := s.oldest()
+
+	assert.Nil(t, s.write(batchOf("third")), "The batch being replayed is dropped to make room")
+	s.remove(replayed, Sent)
+
+	assert.Equal(t, 1, outcomes(Dropped))
+	assert.Equal(t, 0, outcomes(Sent), "A dropped batch should not be counted as sent as well")
+	assert.Equal(t, 2, s.depth())
+}
+
+func TestSpoolIgnoresOtherFiles(t *testing.T) {
+	Init()
+	dir := t.TempDir()
+	partial := filepath.Join(dir, ".00000000000000000000.log")
+	assert.Nil(t, os.WriteFile(partial, []byte("partial"), 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes\n"), 0o600))
+
+	s, err := openSpool(dir, 1<<10, &atomic.Pointer[Hooks]{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, s.depth())
+	assert.NoFileExists(t, partial, "The partial write of a previous run should be deleted")
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+}
+
+func TestShipperReplaysSpoolWhenEndpointRecovers(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	endpoint.answer(http.StatusServiceUnavailable)
+	s := spoolingShipper(t, t.TempDir())
+	outcomes := countOutcomes(s.hooks)
+
+	s.enqueue(entry("first"))
+	assert.Nil(t, s.flush(context.Background()))
+	s.enqueue(entry("second"))
+	assert.Nil(t, s.flush(context.Background()))
+	assert.Equal(t, 2, outcomes(Spooled))
+	assert.Equal(t, 0, outcomes(Failed))
+
+	endpoint.answer(http.StatusOK)
+
+	assert.Eventually(t, func() bool { return s.spool.depth() == 0 }, 2*time.Second, 5*time.Millisecond)
+	received := endpoint.received()
+	assert.Equal(t, [][]string{{"first"}, {"second"}}, received[len(received)-2:],
+		"Spooled entries should be sent in order")
+	assert.Equal(t, 2, outcomes(Sent))
+}
+
+func TestShipperReplaysSpoolOfPreviousRun(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	dir := t.TempDir()
+	previous, err := openSpool(dir, 1<<10, &atomic.Pointer[Hooks]{})
+	assert.Nil(t, err)
+	assert.Nil(t, previous.write(batchOf("left over")))
+
+	spoolingShipper(t, dir)
+
+	assert.Eventually(t, func() bool { return len(endpoint.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, [][]string{{"left over"}}, endpoint.received())
+}
+
+func TestShipperSkipsEndpointWhileBreakerIsOpen(t *testing.T) {
+	endpoint := useLogEndpoint(t)
+	endpoint.answer(http.StatusServiceUnavailable)
+	s := newTestShipper(&configuration.Config{LogBatchSize: 1})
+	outcomes := countOutcomes(s.hooks)
+	s.breaker = resilience.NewBreaker(1, time.Hour)
+
+	s.enqueue(entry("first"))
+	assert.Nil(t, s.flush(context.Background()))
+	s.enqueue(entry("second"))
+	assert.Nil(t, s.flush(context.Background()))
+
+	assert.Len(t, endpoint.received(), 1)
+	assert.Equal(t, resilience.StateOpen, s.breaker.State())
+	assert.Equal(t, 2, outcomes(Failed), "Without a spool the entries are lost")
+}
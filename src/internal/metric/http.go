@@ -0,0 +1,93 @@
+package metric
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const unmatchedRoute = "unmatched"
+
+// InstrumentHandler records count, latency, sizes and in-flight requests for every request served by mux.
+// Requests are labelled by the mux pattern they matched, so unknown paths cannot grow the label set.
+// Only the requests matching one of apiRoutes are counted in the API totals, probes and scrapes are not.
+func InstrumentHandler(mux *http.ServeMux, apiRoutes ...string) http.Handler {
+	api := make(map[string]bool, len(apiRoutes))
+	for _, route := range apiRoutes {
+		api[route] = true
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		route := routeLabel(mux, req)
+		start := time.Now()
+
+		inFlight := HTTPRequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		recorder := &statusRecorder{ResponseWriter: resp, status: http.StatusOK}
+		mux.ServeHTTP(recorder, req)
+
+		code := strconv.Itoa(recorder.status)
+		if api[route] {
+			RequestsTotal.Inc()
+			HelloWorldHTTPRequestsTotal.WithLabelValues(code).Inc()
+			if recorder.status >= http.StatusInternalServerError {
+				RequestsFailedTotal.Inc()
+			}
+		}
+		HTTPRequestsByRouteTotal.WithLabelValues(route, req.Method, code).Inc()
+		HTTPRequestDurationSeconds.WithLabelValues(route, req.Method).Observe(time.Since(start).Seconds())
+		HTTPRequestSizeBytes.WithLabelValues(route, req.Method).Observe(float64(requestSize(req)))
+		HTTPResponseSizeBytes.WithLabelValues(route, req.Method).Observe(float64(recorder.written))
+	})
+}
+
+func routeLabel(mux *http.ServeMux, req *http.Request) string {
+	_, pattern := mux.Handler(req)
+	if pattern == "" {
+		return unmatchedRoute
+	}
+	return pattern
+}
+
+func requestSize(req *http.Request) int64 {
+	if req.ContentLength < 0 {
+		return 0
+	}
+	return req.ContentLength
+}
+
+// statusRecorder captures the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
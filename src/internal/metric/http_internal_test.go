@@ -0,0 +1,50 @@
+package metric
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusRecorderCapturesStatusAndSize(t *testing.T) {
+	response := httptest.NewRecorder()
+	recorder := &statusRecorder{ResponseWriter: response, status: http.StatusOK}
+
+	recorder.WriteHeader(http.StatusTeapot)
+	recorder.WriteHeader(http.StatusInternalServerError)
+	_, err := recorder.Write([]byte("short and stout"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusTeapot, recorder.status, "Only the first status should be recorded")
+	assert.Equal(t, int64(len("short and stout")), recorder.written)
+	assert.Same(t, response, recorder.Unwrap())
+}
+
+func TestStatusRecorderDefaultsToOk(t *testing.T) {
+	recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+
+	_, err := recorder.Write([]byte("Hello World!!"))
+	recorder.WriteHeader(http.StatusInternalServerError)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, recorder.status, "Status is fixed once the body is written")
+}
+
+func TestRouteLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(http.ResponseWriter, *http.Request) {})
+
+	assert.Equal(t, "/hello", routeLabel(mux, httptest.NewRequest(http.MethodGet, "/hello", nil)))
+	assert.Equal(t, unmatchedRoute, routeLabel(mux, httptest.NewRequest(http.MethodGet, "/unknown/123", nil)))
+}
+
+func TestRequestSize(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader("body"))
+	assert.Equal(t, int64(4), requestSize(request))
+
+	request.ContentLength = -1
+	assert.Equal(t, int64(0), requestSize(request))
+}
@@ -17,8 +17,38 @@ var (
 	RequestsFailedTotal prometheus.Counter
 	// HelloWorldHTTPRequestsTotal total number of HTTP responses by status codes
 	HelloWorldHTTPRequestsTotal *prometheus.CounterVec
+	// ConfigReloadsTotal total number of configuration reloads by result
+	ConfigReloadsTotal *prometheus.CounterVec
+	// HTTPRequestsByRouteTotal total number of HTTP requests by route, method and status code
+	HTTPRequestsByRouteTotal *prometheus.CounterVec
+	// HTTPRequestsInFlight number of HTTP requests currently being served by route
+	HTTPRequestsInFlight *prometheus.GaugeVec
+	// HTTPRequestDurationSeconds latency of HTTP requests by route and method
+	HTTPRequestDurationSeconds *prometheus.HistogramVec
+	// HTTPRequestSizeBytes size of HTTP request bodies by route and method
+	HTTPRequestSizeBytes *prometheus.HistogramVec
+	// HTTPResponseSizeBytes size of HTTP response bodies by route and method
+	HTTPResponseSizeBytes *prometheus.HistogramVec
+	// UpstreamFailuresTotal total number of failed calls to other services by target and reason
+	UpstreamFailuresTotal *prometheus.CounterVec
+	// UpstreamRetriesTotal total number of retried calls to other services by target and reason
+	UpstreamRetriesTotal *prometheus.CounterVec
+	// UpstreamRequestsTotal total number of calls to platform APIs by target, method and status code
+	UpstreamRequestsTotal *prometheus.CounterVec
+	// UpstreamRequestDurationSeconds latency of calls to platform APIs by target and method
+	UpstreamRequestDurationSeconds *prometheus.HistogramVec
+	// CircuitBreakerState is 1 for the current state of the circuit breaker of each target, 0 for the others
+	CircuitBreakerState *prometheus.GaugeVec
+	// LogEntriesTotal total number of remote log entries by outcome: queued, sent, failed or dropped
+	LogEntriesTotal *prometheus.CounterVec
+	// LogSpoolSize entries and bytes of remote log entries waiting in LOG_SPOOL_DIR, by unit
+	LogSpoolSize *prometheus.GaugeVec
+	// LogLevel is 1 for the current log level, 0 for the others
+	LogLevel *prometheus.GaugeVec
 )
 
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+
 func createMetrics() {
 	RequestsTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -39,12 +69,128 @@ func createMetrics() {
 			Help:      "Total number of HTTP responses by status codes",
 		},
 		[]string{"code"})
+	ConfigReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "config_reloads_total",
+			Help:      "Total number of configuration reloads by result",
+		},
+		[]string{"result"})
+	HTTPRequestsByRouteTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "http_requests_by_route_total",
+			Help:      "Total number of HTTP requests by route, method and status code",
+		},
+		[]string{"route", "method", "code"})
+	HTTPRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: servicePrefix,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being served",
+		},
+		[]string{"route"})
+	HTTPRequestDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: servicePrefix,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"route", "method"})
+	HTTPRequestSizeBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: servicePrefix,
+			Name:      "http_request_size_bytes",
+			Help:      "Size of HTTP request bodies",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"route", "method"})
+	HTTPResponseSizeBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: servicePrefix,
+			Name:      "http_response_size_bytes",
+			Help:      "Size of HTTP response bodies",
+			Buckets:   sizeBuckets,
+		},
+		[]string{"route", "method"})
+	UpstreamFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "upstream_failures_total",
+			Help:      "Total number of failed calls to other services by target and reason",
+		},
+		[]string{"target", "reason"})
+	UpstreamRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "upstream_retries_total",
+			Help:      "Total number of retried calls to other services by target and reason",
+		},
+		[]string{"target", "reason"})
+	UpstreamRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "upstream_requests_total",
+			Help:      "Total number of calls to platform APIs by target, method and status code",
+		},
+		[]string{"target", "method", "code"})
+	UpstreamRequestDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: servicePrefix,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Latency of calls to platform APIs",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"target", "method"})
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: servicePrefix,
+			Name:      "circuit_breaker_state",
+			Help:      "Current state of the circuit breaker of each target, set to 1 for the active state",
+		},
+		[]string{"target", "state"})
+	LogEntriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: servicePrefix,
+			Name:      "log_entries_total",
+			Help:      "Total number of remote log entries by outcome",
+		},
+		[]string{"outcome"})
+	LogSpoolSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: servicePrefix,
+			Name:      "log_spool_size",
+			Help:      "Remote log entries waiting in the spool to be sent again, in entries and bytes",
+		},
+		[]string{"unit"})
+	LogLevel = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: servicePrefix,
+			Name:      "log_level",
+			Help:      "Current log level, set to 1 for the active severity",
+		},
+		[]string{"severity"})
 }
 
 func registerMetrics() {
-	Registry.Register(RequestsTotal)               //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
-	Registry.Register(RequestsFailedTotal)         //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
-	Registry.Register(HelloWorldHTTPRequestsTotal) //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(RequestsTotal)                  //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(RequestsFailedTotal)            //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HelloWorldHTTPRequestsTotal)    //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(ConfigReloadsTotal)             //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HTTPRequestsByRouteTotal)       //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HTTPRequestsInFlight)           //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HTTPRequestDurationSeconds)     //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HTTPRequestSizeBytes)           //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(HTTPResponseSizeBytes)          //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(UpstreamFailuresTotal)          //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(UpstreamRetriesTotal)           //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(UpstreamRequestsTotal)          //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(UpstreamRequestDurationSeconds) //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(CircuitBreakerState)            //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(LogEntriesTotal)                //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(LogSpoolSize)                   //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
+	Registry.Register(LogLevel)                       //nolint:errcheck // handling invalid metrics descriptors is outside the app scope
 }
 
 // SetupMetrics sets up the metrics
@@ -22,6 +22,28 @@ func TestCreateMetrics(t *testing.T) {
 		"RequestsFailedTotal has not been initialized")
 	assert.NotNil(t, metric.HelloWorldHTTPRequestsTotal,
 		"HelloWorldHTTPRequestsTotal has not been initialized")
+	assert.NotNil(t, metric.ConfigReloadsTotal,
+		"ConfigReloadsTotal has not been initialized")
+	assert.NotNil(t, metric.HTTPRequestsByRouteTotal,
+		"HTTPRequestsByRouteTotal has not been initialized")
+	assert.NotNil(t, metric.HTTPRequestsInFlight,
+		"HTTPRequestsInFlight has not been initialized")
+	assert.NotNil(t, metric.HTTPRequestDurationSeconds,
+		"HTTPRequestDurationSeconds has not been initialized")
+	assert.NotNil(t, metric.HTTPRequestSizeBytes,
+		"HTTPRequestSizeBytes has not been initialized")
+	assert.NotNil(t, metric.HTTPResponseSizeBytes,
+		"HTTPResponseSizeBytes has not been initialized")
+	assert.NotNil(t, metric.UpstreamFailuresTotal,
+		"UpstreamFailuresTotal has not been initialized")
+	assert.NotNil(t, metric.UpstreamRetriesTotal,
+		"UpstreamRetriesTotal has not been initialized")
+	assert.NotNil(t, metric.UpstreamRequestsTotal,
+		"UpstreamRequestsTotal has not been initialized")
+	assert.NotNil(t, metric.UpstreamRequestDurationSeconds,
+		"UpstreamRequestDurationSeconds has not been initialized")
+	assert.NotNil(t, metric.CircuitBreakerState,
+		"CircuitBreakerState has not been initialized")
 }
 
 func TestRegisterMetrics(t *testing.T) {
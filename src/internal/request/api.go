@@ -0,0 +1,216 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxPages stops GetAll from following next links forever
+const maxPages = 1000
+
+// Tokens provides the bearer tokens of an APIClient, see TokenSource and TokenExchanger.OnBehalfOf
+type Tokens interface {
+	// Token returns a valid token
+	Token(ctx context.Context) (*Token, error)
+	// Invalidate drops a cached token the API did not accept
+	Invalidate()
+}
+
+// APIClient calls a platform API with a bearer token, encoding and decoding JSON
+type APIClient struct {
+	target  string
+	baseURL string
+	tokens  Tokens
+}
+
+// NewAPIClient Create an APIClient for the API at baseURL. target names the API in metrics and logs.
+func NewAPIClient(target, baseURL string, tokens Tokens) *APIClient {
+	return &APIClient{target: target, baseURL: strings.TrimRight(baseURL, "/"), tokens: tokens}
+}
+
+// Get decodes the JSON response of path into out, unless out is nil
+func (c *APIClient) Get(ctx context.Context, path string, out any) error {
+	_, err := c.Do(ctx, http.MethodGet, path, nil, out)
+	return err
+}
+
+// Post sends in as JSON to path and decodes the response into out, unless out is nil
+func (c *APIClient) Post(ctx context.Context, path string, in, out any) error {
+	_, err := c.Do(ctx, http.MethodPost, path, in, out)
+	return err
+}
+
+// Put sends in as JSON to path and decodes the response into out, unless out is nil
+func (c *APIClient) Put(ctx context.Context, path string, in, out any) error {
+	_, err := c.Do(ctx, http.MethodPut, path, in, out)
+	return err
+}
+
+// Patch sends in as JSON to path and decodes the response into out, unless out is nil
+func (c *APIClient) Patch(ctx context.Context, path string, in, out any) error {
+	_, err := c.Do(ctx, http.MethodPatch, path, in, out)
+	return err
+}
+
+// Delete deletes path and decodes the response into out, unless out is nil
+func (c *APIClient) Delete(ctx context.Context, path string, out any) error {
+	_, err := c.Do(ctx, http.MethodDelete, path, nil, out)
+	return err
+}
+
+// Do sends in as JSON, unless it is nil, and decodes the response into out, unless it is nil.
+// path is relative to the base URL or an absolute URL. When the API answers 401 the token is
+// renewed and the request sent once more. The response is decoded while it is read, bounded by
+// HTTP_MAX_RESPONSE_SIZE, and the returned response has its body already read.
+func (c *APIClient) Do(ctx context.Context, method, path string, in, out any) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, fmt.Errorf("JSON Marshal Failed with following error: %w", err)
+		}
+	}
+
+	resp, err := c.send(ctx, method, c.url(path), body, out)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+		// the token may have been revoked or expired early, a new one is tried once
+		c.tokens.Invalidate()
+		resp, err = c.send(ctx, method, c.url(path), body, out)
+	}
+	return resp, err
+}
+
+func (c *APIClient) url(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.baseURL + "/" + strings.TrimLeft(path, "/")
+}
+
+// send makes the call and decodes its response into out, the hooks see decoding failures too
+func (c *APIClient) send(ctx context.Context, method, endpoint string, body []byte, out any) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.sendWithToken(ctx, method, endpoint, body)
+	if err == nil {
+		err = c.decode(resp, out)
+	}
+
+	if h := hooks.Load(); h != nil && h.OnAPICall != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		h.OnAPICall(c.target, method, status, time.Since(start), err)
+	}
+	return resp, err
+}
+
+// decode reads the body of a successful response into out, or discards it when out is nil
+func (c *APIClient) decode(resp *http.Response, out any) error {
+	defer resp.Body.Close() //nolint:errcheck //error has no impact
+	if out == nil {
+		drain(resp.Body, apiMaxResponseSize())
+		return nil
+	}
+	return DecodeJSON(resp, apiMaxResponseSize(), out)
+}
+
+func (c *APIClient) sendWithToken(ctx context.Context, method, endpoint string, body []byte,
+) (*http.Response, error) {
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get a token for %s: %w", c.target, err)
+	}
+
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, fmt.Errorf("Create http.Request object failed: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return open(req)
+}
+
+// GetAll decodes the JSON array of every page of path into a single list, following the
+// rel="next" links of the Link header (RFC 8288). A next link to another scheme or host than the
+// base URL is not followed, the token would be sent to it.
+func GetAll[T any](ctx context.Context, c *APIClient, path string) ([]T, error) {
+	var items []T
+	next := c.url(path)
+	for page := 0; next != ""; page++ {
+		if page == maxPages {
+			return items, fmt.Errorf("%s has more than %d pages", redactEndpoint(c.url(path)), maxPages)
+		}
+		var pageItems []T
+		resp, err := c.Do(ctx, http.MethodGet, next, nil, &pageItems)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, pageItems...)
+		next = nextLink(resp)
+		if next != "" && !c.sameOrigin(next) {
+			return items, fmt.Errorf("%s: %w %s", redactEndpoint(c.url(path)), ErrForeignLink, redactEndpoint(next))
+		}
+	}
+	return items, nil
+}
+
+// sameOrigin reports whether link has the scheme and host of the base URL
+func (c *APIClient) sameOrigin(link string) bool {
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(link)
+	return err == nil && strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
+
+// nextLink returns the absolute URL of the rel="next" link of the response, or an empty string
+func nextLink(resp *http.Response) string {
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(name, "rel") || !containsFold(strings.Fields(strings.Trim(value, `"`)), "next") {
+					continue
+				}
+				ref, err := url.Parse(strings.Trim(target, "<>"))
+				if err != nil {
+					return ""
+				}
+				return resp.Request.URL.ResolveReference(ref).String()
+			}
+		}
+	}
+	return ""
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
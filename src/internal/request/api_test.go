@@ -0,0 +1,258 @@
+package request_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+// countingTokens hands out token-1, token-2... each time a new token is needed
+func countingTokens(logins *int32) *request.TokenSource {
+	return request.NewTokenSource(func(context.Context) (*request.Token, error) {
+		n := atomic.AddInt32(logins, 1)
+		return &request.Token{AccessToken: "token-" + strconv.Itoa(int(n)), ExpiresIn: 300}, nil
+	})
+}
+
+func TestAPIClientSendsJSONWithBearerToken(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		assert.Equal(t, "Bearer token-1", req.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, http.MethodPut, req.Method)
+		assert.Equal(t, "/api/v1/items/a", req.URL.Path)
+		var in item
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&in))
+		json.NewEncoder(rw).Encode(item{Name: in.Name + "-updated"}) //nolint:errcheck,errchkjson //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL+"/api/v1/", countingTokens(&logins))
+
+	var out item
+	err := api.Put(context.Background(), "/items/a", item{Name: "a"}, &out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a-updated", out.Name)
+}
+
+func TestAPIClientMethods(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		methods = append(methods, req.Method)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+	ctx := context.Background()
+
+	assert.Nil(t, api.Get(ctx, "items", nil))
+	assert.Nil(t, api.Post(ctx, "items", item{}, nil))
+	assert.Nil(t, api.Patch(ctx, "items/a", item{}, nil))
+	assert.Nil(t, api.Delete(ctx, "items/a", &item{}))
+
+	assert.Equal(t, []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete}, methods)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins), "Token should be reused")
+}
+
+func TestAPIClientReauthenticatesOnceOnUnauthorized(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		atomic.AddInt32(&calls, 1)
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte(`{"name":"a"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	var out item
+	err := api.Get(context.Background(), "items/a", &out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a", out.Name)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&logins))
+}
+
+func TestAPIClientGivesUpAfterSecondUnauthorized(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	err := api.Get(context.Background(), "items", nil)
+
+	var httpErr *request.HTTPError
+	assert.True(t, errors.As(err, &httpErr), "error should be an HTTPError")
+	assert.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestAPIClientDecodeFailure(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`not json`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	err := api.Get(context.Background(), "items", &item{})
+
+	assert.ErrorIs(t, err, request.ErrDecode)
+}
+
+func TestAPIClientRejectsOtherContentTypes(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/html")
+		rw.Write([]byte(`{"name":"html"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	var out item
+	err := api.Get(context.Background(), "items", &out)
+
+	assert.ErrorIs(t, err, request.ErrContentType)
+	assert.Equal(t, "content_type", request.ErrorReason(err))
+	assert.Empty(t, out.Name)
+}
+
+func TestAPIClientTokenFailure(t *testing.T) {
+	t.Parallel()
+	api := request.NewAPIClient("items", "https://api.test", request.NewTokenSource(
+		func(context.Context) (*request.Token, error) { return nil, errors.New("login failed") }))
+
+	err := api.Get(context.Background(), "items", nil)
+
+	assert.ErrorContains(t, err, "could not get a token for items: login failed")
+}
+
+func TestGetAllFollowsNextLinks(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Query().Get("page") {
+		case "":
+			rw.Header().Set("Link", `</items?page=2>; rel="next", </items?page=9>; rel="last"`)
+			rw.Write([]byte(`[{"name":"a"},{"name":"b"}]`)) //nolint:errcheck //mock server, no error handling required
+		case "2":
+			rw.Header().Add("Link", `<?page=3>; rel="prev next"`)
+			rw.Write([]byte(`[{"name":"c"}]`)) //nolint:errcheck //mock server, no error handling required
+		default:
+			rw.Write([]byte(`[]`)) //nolint:errcheck //mock server, no error handling required
+		}
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	items, err := request.GetAll[item](context.Background(), api, "/items")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []item{{"a"}, {"b"}, {"c"}}, items)
+}
+
+func TestGetAllStopsOnError(t *testing.T) {
+	t.Parallel()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if req.URL.Query().Get("page") == "2" {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.Header().Set("Link", `</items?page=2>; rel=next`)
+		rw.Write([]byte(`[{"name":"a"}]`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	items, err := request.GetAll[item](context.Background(), api, "/items")
+
+	assert.Equal(t, "http_502", request.ErrorReason(err))
+	assert.Equal(t, []item{{"a"}}, items)
+}
+
+func TestGetAllRejectsForeignNextLinks(t *testing.T) {
+	t.Parallel()
+	var foreignCalls int32
+	foreign := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&foreignCalls, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`[{"name":"stolen"}]`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer foreign.Close()
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Link", "<"+foreign.URL+"/items?page=2>; rel=next")
+		rw.Write([]byte(`[{"name":"a"}]`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	items, err := request.GetAll[item](context.Background(), api, "/items")
+
+	assert.ErrorIs(t, err, request.ErrForeignLink)
+	assert.Equal(t, []item{{"a"}}, items)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&foreignCalls), "The token should not be sent to another host")
+}
+
+func TestAPIClientCallsHook(t *testing.T) {
+	var logins int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	type call struct {
+		target, method string
+		status         int
+		err            error
+	}
+	var calls []call
+	request.SetHooks(request.Hooks{OnAPICall: func(target, method string, status int, duration time.Duration, err error) {
+		calls = append(calls, call{target, method, status, err})
+	}})
+	t.Cleanup(func() { request.SetHooks(request.Hooks{}) })
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	err := api.Delete(context.Background(), "items/a", nil)
+
+	assert.Len(t, calls, 1)
+	assert.Equal(t, "items", calls[0].target)
+	assert.Equal(t, http.MethodDelete, calls[0].method)
+	assert.Equal(t, http.StatusNotFound, calls[0].status)
+	assert.Equal(t, err, calls[0].err)
+}
@@ -0,0 +1,128 @@
+package request
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+)
+
+const (
+	defaultIamMaxResponseSize = 1 << 20
+	defaultAPIMaxResponseSize = 4 << 20
+)
+
+// iamMaxResponseSize Returns IAM_MAX_RESPONSE_SIZE, the largest response body accepted from the IAM
+func iamMaxResponseSize() int64 {
+	if size := configuration.Current().IamMaxResponseSize; size > 0 {
+		return int64(size)
+	}
+	return defaultIamMaxResponseSize
+}
+
+// apiMaxResponseSize Returns HTTP_MAX_RESPONSE_SIZE, the largest response body accepted by an APIClient
+func apiMaxResponseSize() int64 {
+	if size := configuration.Current().HTTPMaxResponseSize; size > 0 {
+		return int64(size)
+	}
+	return defaultAPIMaxResponseSize
+}
+
+// ReadBody reads the body of resp, failing with ErrResponseTooLarge instead of reading more than maxSize bytes
+func ReadBody(resp *http.Response, maxSize int64) ([]byte, error) {
+	if resp.ContentLength > maxSize {
+		return nil, tooLarge(resp, maxSize)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Reading response body failed: %w", classifyTransportError(err))
+	}
+	if int64(len(body)) > maxSize {
+		return nil, tooLarge(resp, maxSize)
+	}
+	return body, nil
+}
+
+// CheckJSON fails with ErrContentType unless resp declares application/json or a +json media type
+func CheckJSON(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+	return fmt.Errorf("%s returned Content-Type %q instead of JSON: %w", responseEndpoint(resp), contentType,
+		ErrContentType)
+}
+
+// DecodeJSON decodes the JSON body of resp into v while reading it, so the body is never held in memory
+// as a whole. It fails with ErrContentType when resp is not JSON and with ErrResponseTooLarge instead of
+// reading more than maxSize bytes, v may then be partly decoded. An empty body leaves v unchanged.
+func DecodeJSON(resp *http.Response, maxSize int64, v any) error {
+	if resp.ContentLength > maxSize {
+		return tooLarge(resp, maxSize)
+	}
+	source := &recordingReader{r: resp.Body}
+	limited := &io.LimitedReader{R: source, N: maxSize + 1}
+	body := bufio.NewReader(limited)
+	if _, err := body.Peek(1); errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err := CheckJSON(resp); err != nil {
+		return err
+	}
+
+	err := json.NewDecoder(body).Decode(v)
+	if limited.N == 0 {
+		return tooLarge(resp, maxSize)
+	}
+	if source.err != nil && !errors.Is(source.err, io.EOF) {
+		return fmt.Errorf("Reading response body failed: %w", classifyTransportError(source.err))
+	}
+	if err != nil {
+		return fmt.Errorf("JSON Unmarshal Failed with following error: %w: %w", ErrDecode, err)
+	}
+	return nil
+}
+
+// recordingReader keeps the error of the underlying reader, which the JSON decoder does not tell apart
+// from a syntax error
+type recordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// drain discards what is left of a response body, up to maxSize bytes, so the connection can be reused
+func drain(body io.Reader, maxSize int64) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxSize))
+}
+
+func tooLarge(resp *http.Response, maxSize int64) error {
+	return fmt.Errorf("%s returned a response body larger than %d bytes: %w", responseEndpoint(resp), maxSize,
+		ErrResponseTooLarge)
+}
+
+func responseEndpoint(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.URL == nil {
+		return "response"
+	}
+	return redactEndpoint(resp.Request.URL.String())
+}
+
+// acceptsOnlyJSON reports whether req asked for a JSON response, whose Content-Type is then checked
+func acceptsOnlyJSON(req *http.Request) bool {
+	return req.Header.Get("Accept") == "application/json"
+}
@@ -0,0 +1,129 @@
+package request_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// response builds a response whose length is unknown, as for a chunked body
+func response(contentType, body string) *http.Response {
+	resp := &http.Response{Header: http.Header{}, ContentLength: -1, Body: io.NopCloser(strings.NewReader(body))}
+	if contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	return resp
+}
+
+func useMaxResponseSizes(t *testing.T, iam, api string) {
+	t.Setenv("IAM_MAX_RESPONSE_SIZE", iam)
+	t.Setenv("HTTP_MAX_RESPONSE_SIZE", api)
+	t.Setenv("IAM_RETRY_INITIAL_BACKOFF", "1ms")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+}
+
+func TestReadBody(t *testing.T) {
+	t.Parallel()
+	body, err := request.ReadBody(response("", "0123456789"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	_, err = request.ReadBody(response("", "0123456789a"), 10)
+	assert.ErrorIs(t, err, request.ErrResponseTooLarge)
+
+	declared := response("", "")
+	declared.ContentLength = 11
+	_, err = request.ReadBody(declared, 10)
+	assert.ErrorIs(t, err, request.ErrResponseTooLarge, "A declared length should be refused before reading")
+}
+
+func TestCheckJSON(t *testing.T) {
+	t.Parallel()
+	for contentType, valid := range map[string]bool{
+		"application/json":                true,
+		"application/json; charset=UTF-8": true,
+		"application/jwk-set+json":        true,
+		"application/problem+json":        true,
+		"text/plain; charset=utf-8":       false,
+		"text/html":                       false,
+		"":                                false,
+		"application/json; =":             false,
+	} {
+		err := request.CheckJSON(response(contentType, ""))
+		if valid {
+			assert.Nil(t, err, contentType)
+		} else {
+			assert.ErrorIs(t, err, request.ErrContentType, contentType)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	t.Parallel()
+	var out item
+	assert.Nil(t, request.DecodeJSON(response("application/json", `{"name":"a"}`), 12, &out))
+	assert.Equal(t, "a", out.Name)
+
+	err := request.DecodeJSON(response("application/json", `{"name":"ab"}`), 12, &out)
+	assert.ErrorIs(t, err, request.ErrResponseTooLarge)
+	assert.Equal(t, "too_large", request.ErrorReason(err))
+
+	err = request.DecodeJSON(response("application/json", `{"name":`), 12, &out)
+	assert.ErrorIs(t, err, request.ErrDecode)
+
+	out = item{}
+	err = request.DecodeJSON(response("text/plain", `{"name":"b"}`), 12, &out)
+	assert.ErrorIs(t, err, request.ErrContentType)
+	assert.Empty(t, out.Name, "A response that is not JSON should not be decoded")
+
+	assert.Nil(t, request.DecodeJSON(response("", ""), 12, &out), "An empty body has no content type to check")
+}
+
+func TestAPIClientRejectsLargeResponses(t *testing.T) {
+	useMaxResponseSizes(t, "", "16")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"name":"` + strings.Repeat("x", 64) + `"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	var logins int32
+	api := request.NewAPIClient("items", server.URL, countingTokens(&logins))
+
+	err := api.Get(context.Background(), "items", &item{})
+	assert.ErrorIs(t, err, request.ErrResponseTooLarge)
+
+	err = api.Get(context.Background(), "items", nil)
+	assert.Nil(t, err, "A response that is not decoded is discarded")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestLoginRejectsLargeResponsesWithoutRetrying(t *testing.T) {
+	useMaxResponseSizes(t, "64", "")
+	t.Cleanup(request.Reload)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "application/json", req.Header.Get("Accept"))
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"access_token":"` + strings.Repeat("x", 64) + `"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	_, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+
+	assert.ErrorIs(t, err, request.ErrResponseTooLarge)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,87 @@
+package request
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// clientAssertionLifetime is kept short, the assertion is only used for a single request
+	clientAssertionLifetime = time.Minute
+)
+
+// needsClientSecret reports whether the configured client authentication method uses the client secret
+func needsClientSecret() bool {
+	switch configuration.Current().IamClientAuthMethod {
+	case configuration.PrivateKeyJWT, configuration.TLSClientAuth:
+		return false
+	}
+	return true
+}
+
+// authenticate adds the client authentication of the configured method to a token request.
+// It is called for every attempt, as a client assertion must not be sent twice.
+func authenticate(formData url.Values, headers http.Header, clientID, clientSecret, tokenURL string) error {
+	switch method := configuration.Current().IamClientAuthMethod; method {
+	case "", configuration.ClientSecretPost:
+		return nil
+	case configuration.ClientSecretBasic:
+		formData.Del("client_secret")
+		// RFC 6749 section 2.3.1 form encodes the credentials before the Basic encoding
+		credentials := url.QueryEscape(clientID) + ":" + url.QueryEscape(clientSecret)
+		headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case configuration.PrivateKeyJWT:
+		formData.Del("client_secret")
+		assertion, err := clientAssertion(clientID, tokenURL)
+		if err != nil {
+			return err
+		}
+		formData.Set("client_assertion_type", clientAssertionType)
+		formData.Set("client_assertion", assertion)
+	case configuration.TLSClientAuth:
+		// the client is authenticated by the app certificate presented by the TLS configuration
+		formData.Del("client_secret")
+	default:
+		return fmt.Errorf("Unsupported IAM_CLIENT_AUTH_METHOD %q", method)
+	}
+	return nil
+}
+
+// clientAssertion returns a JWT signed with the app key, as defined in RFC 7523 section 2.2
+func clientAssertion(clientID, tokenURL string) (string, error) {
+	cert, err := configuration.AppKeyPair()
+	if err != nil {
+		return "", fmt.Errorf("could not load the app key for private_key_jwt: %w", err)
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return "", errors.New("the app key cannot be used for signing")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.Audience{tokenURL},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(clientAssertionLifetime).Unix(),
+		ID:        hex.EncodeToString(id),
+	}
+	header := jwt.Header{X5TS256: jwt.Thumbprint(cert.Certificate[0])}
+	return jwt.Sign(header, claims, key)
+}
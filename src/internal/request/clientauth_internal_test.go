@@ -0,0 +1,178 @@
+package request
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useAuthMethod configures method with a freshly generated app key pair
+func useAuthMethod(t *testing.T, method string) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "app"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "app.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "app.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	t.Setenv("IAM_CLIENT_AUTH_METHOD", method)
+	t.Setenv("APP_CERT_FILE_PATH", dir)
+	t.Setenv("APP_CERT", "app.crt")
+	t.Setenv("APP_KEY", "app.key")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+}
+
+// tokenEndpoint records the forms and headers of the token requests it answers
+func tokenEndpoint(t *testing.T, status int) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		assert.Nil(t, req.ParseForm())
+		requests = append(requests, req)
+		rw.WriteHeader(status)
+		rw.Write([]byte(`{"access_token":"testToken"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestLoginWithClientSecretBasic(t *testing.T) {
+	useAuthMethod(t, configuration.ClientSecretBasic)
+	server, requests := tokenEndpoint(t, http.StatusOK)
+
+	_, err := Login(context.Background(), "test:ID", "test Secret", server.URL)
+
+	assert.Nil(t, err)
+	req := (*requests)[0]
+	assert.Equal(t, "", req.PostForm.Get("client_secret"), "Secret should not be in the body")
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("test%3AID:test+Secret")),
+		req.Header.Get("Authorization"))
+}
+
+func TestLoginWithPrivateKeyJWT(t *testing.T) {
+	useAuthMethod(t, configuration.PrivateKeyJWT)
+	t.Setenv("IAM_RETRY_MAX_ATTEMPTS", "2")
+	t.Setenv("IAM_RETRY_INITIAL_BACKOFF", "1ms")
+	configuration.ReloadAppConfig()
+	server, requests := tokenEndpoint(t, http.StatusServiceUnavailable)
+	t.Cleanup(resetBreakers)
+
+	_, err := Login(context.Background(), "testID", "", server.URL)
+
+	assert.NotNil(t, err)
+	assert.Len(t, *requests, 2)
+	var ids []string
+	for _, req := range *requests {
+		assert.Equal(t, "", req.PostForm.Get("client_secret"))
+		assert.Equal(t, clientAssertionType, req.PostForm.Get("client_assertion_type"))
+		parts := strings.Split(req.PostForm.Get("client_assertion"), ".")
+		assert.Len(t, parts, 3)
+		var header, claims map[string]any
+		data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		assert.Nil(t, json.Unmarshal(data, &header))
+		data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+		assert.Nil(t, json.Unmarshal(data, &claims))
+
+		assert.Equal(t, "ES256", header["alg"])
+		assert.NotEmpty(t, header["x5t#S256"])
+		assert.Equal(t, "testID", claims["iss"])
+		assert.Equal(t, "testID", claims["sub"])
+		assert.Equal(t, LoginURL(server.URL), claims["aud"])
+		ids = append(ids, claims["jti"].(string))
+	}
+	assert.NotEqual(t, ids[0], ids[1], "Every attempt should use a new assertion")
+}
+
+func TestLoginWithPrivateKeyJWTWithoutKey(t *testing.T) {
+	useAuthMethod(t, configuration.PrivateKeyJWT)
+	t.Setenv("APP_KEY", "missing.key")
+	configuration.ReloadAppConfig()
+	server, requests := tokenEndpoint(t, http.StatusOK)
+
+	_, err := Login(context.Background(), "testID", "", server.URL)
+
+	assert.ErrorContains(t, err, "could not load the app key for private_key_jwt")
+	assert.Empty(t, *requests)
+}
+
+func TestLoginWithTLSClientAuth(t *testing.T) {
+	useAuthMethod(t, configuration.TLSClientAuth)
+	server, requests := tokenEndpoint(t, http.StatusOK)
+
+	_, err := Login(context.Background(), "testID", "", server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testID", (*requests)[0].PostForm.Get("client_id"))
+	assert.Equal(t, "", (*requests)[0].PostForm.Get("client_secret"))
+}
+
+func TestLoginWithTLSClientAuthPresentsAppCertificate(t *testing.T) {
+	original := configuration.Current()
+	// runs last, once the environment is restored
+	t.Cleanup(func() {
+		configuration.SetAppConfig(original)
+		Reload()
+	})
+	useAuthMethod(t, configuration.TLSClientAuth)
+	var clientCN string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		clientCN = req.TLS.PeerCertificates[0].Subject.CommonName
+		rw.Write([]byte(`{"access_token":"testToken"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS13}
+	server.StartTLS()
+	defer server.Close()
+	caDir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, os.WriteFile(filepath.Join(caDir, "ca.crt"), caPEM, 0o600))
+	t.Setenv("CA_CERT_FILE_PATH", caDir)
+	t.Setenv("CA_CERT_FILE_NAME", "ca.crt")
+	configuration.ReloadAppConfig()
+	Reload()
+
+	_, err := Login(context.Background(), "testID", "", server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "app", clientCN)
+}
+
+func TestLoginRequiresSecretForSecretMethods(t *testing.T) {
+	useAuthMethod(t, configuration.ClientSecretBasic)
+
+	_, err := Login(context.Background(), "testID", "", "https://iam.test")
+
+	assert.ErrorContains(t, err, "Empty parameters provided for IamClientID or IamClientSecret")
+}
@@ -0,0 +1,151 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+)
+
+const discoveryPath = "/.well-known/openid-configuration"
+
+// ProviderMetadata is the part of the OpenID Provider Metadata used by the app
+type ProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri,omitempty"`
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint    string `json:"revocation_endpoint,omitempty"`
+}
+
+// discovered caches the metadata by discovery URL until Reload
+var (
+	discoveredMu sync.Mutex
+	discovered   = map[string]*ProviderMetadata{}
+)
+
+func resetDiscovery() {
+	discoveredMu.Lock()
+	defer discoveredMu.Unlock()
+	discovered = map[string]*ProviderMetadata{}
+}
+
+// DiscoveryURL Returns the OpenID Provider configuration URL of the configured realm for the given IAM base URL
+func DiscoveryURL(baseURL string) string {
+	return baseURL + path.Join(configuration.Current().RealmPath(), discoveryPath)
+}
+
+// Discover fetches the OpenID Provider Metadata of the configured realm, a successful response is cached.
+// The metadata must name the realm URL as its issuer (OpenID Connect Discovery 1.0 section 4.3),
+// otherwise its endpoints are not trusted.
+func Discover(ctx context.Context, baseURL string) (*ProviderMetadata, error) {
+	discoveryURL := DiscoveryURL(baseURL)
+
+	discoveredMu.Lock()
+	metadata, ok := discovered[discoveryURL]
+	discoveredMu.Unlock()
+	if ok {
+		return metadata, nil
+	}
+
+	metadata = &ProviderMetadata{}
+	if err := FetchJSON(ctx, discoveryURL, metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if u, err := url.Parse(metadata.TokenEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("OIDC discovery of %s returned no valid token_endpoint: %w",
+			redactEndpoint(discoveryURL), ErrDecode)
+	}
+	if issuer := baseURL + configuration.Current().RealmPath(); metadata.Issuer != issuer {
+		return nil, fmt.Errorf("OIDC discovery of %s returned issuer %q instead of %q: %w",
+			redactEndpoint(discoveryURL), metadata.Issuer, issuer, ErrIssuerMismatch)
+	}
+
+	discoveredMu.Lock()
+	discovered[discoveryURL] = metadata
+	discoveredMu.Unlock()
+	return metadata, nil
+}
+
+// TokenURL Returns the token endpoint for the given IAM base URL, discovered when IAM_OIDC_DISCOVERY is set
+func TokenURL(ctx context.Context, baseURL string) (string, error) {
+	if !configuration.Current().IamOIDCDiscovery {
+		return LoginURL(baseURL), nil
+	}
+	metadata, err := Discover(ctx, baseURL)
+	if err != nil {
+		return "", err
+	}
+	return metadata.TokenEndpoint, nil
+}
+
+// Issuer Returns the issuer of the tokens of the configured realm, discovered when IAM_OIDC_DISCOVERY is set
+func Issuer(ctx context.Context, baseURL string) (string, error) {
+	if config := configuration.Current(); !config.IamOIDCDiscovery {
+		return baseURL + config.RealmPath(), nil
+	}
+	metadata, err := Discover(ctx, baseURL)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Issuer, nil
+}
+
+// JWKSURL Returns the URL of the signing keys of the configured realm, discovered when IAM_OIDC_DISCOVERY is set
+func JWKSURL(ctx context.Context, baseURL string) (string, error) {
+	return realmEndpoint(ctx, baseURL, "/protocol/openid-connect/certs", "jwks_uri",
+		func(m *ProviderMetadata) string { return m.JWKSURI })
+}
+
+// IntrospectionURL Returns the RFC 7662 token introspection endpoint of the configured realm,
+// discovered when IAM_OIDC_DISCOVERY is set
+func IntrospectionURL(ctx context.Context, baseURL string) (string, error) {
+	return realmEndpoint(ctx, baseURL, "/protocol/openid-connect/token/introspect", "introspection_endpoint",
+		func(m *ProviderMetadata) string { return m.IntrospectionEndpoint })
+}
+
+// RevocationURL Returns the RFC 7009 token revocation endpoint of the configured realm,
+// discovered when IAM_OIDC_DISCOVERY is set
+func RevocationURL(ctx context.Context, baseURL string) (string, error) {
+	return realmEndpoint(ctx, baseURL, "/protocol/openid-connect/revoke", "revocation_endpoint",
+		func(m *ProviderMetadata) string { return m.RevocationEndpoint })
+}
+
+// realmEndpoint returns the Keycloak endpoint at realmRelativePath, or the one named by the metadata
+// field when IAM_OIDC_DISCOVERY is set
+func realmEndpoint(ctx context.Context, baseURL, realmRelativePath, field string,
+	pick func(*ProviderMetadata) string,
+) (string, error) {
+	if config := configuration.Current(); !config.IamOIDCDiscovery {
+		return baseURL + path.Join(config.RealmPath(), realmRelativePath), nil
+	}
+	metadata, err := Discover(ctx, baseURL)
+	if err != nil {
+		return "", err
+	}
+	endpoint := pick(metadata)
+	if endpoint == "" {
+		return "", fmt.Errorf("OIDC discovery of %s returned no %s: %w", redactEndpoint(DiscoveryURL(baseURL)), field, ErrDecode)
+	}
+	return endpoint, nil
+}
+
+// FetchJSON gets endpoint with the IAM client and decodes its JSON response into v,
+// bounded by IAM_MAX_RESPONSE_SIZE
+func FetchJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("Create http.Request object failed: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := open(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck //error has no impact
+	return DecodeJSON(resp, iamMaxResponseSize(), v)
+}
@@ -0,0 +1,121 @@
+package request_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useDiscovery(t *testing.T, realm string) {
+	t.Setenv("IAM_OIDC_DISCOVERY", "true")
+	t.Setenv("IAM_REALM", realm)
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+	t.Cleanup(request.Reload)
+}
+
+func TestLoginUsesDiscoveredTokenEndpoint(t *testing.T) {
+	useDiscovery(t, "apps")
+	var iam *fakeiam.IAM
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		iam.ServeHTTP(rw, req)
+	}))
+	defer server.Close()
+	iam = fakeiam.New(server.URL, "apps")
+	iam.AddClient("testID", fakeiam.Client{Secret: "testSecret"})
+
+	for i := 0; i < 2; i++ {
+		token, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, token.AccessToken)
+	}
+	assert.Equal(t, 1, iam.Requests(fakeiam.DiscoveryEndpoint), "Discovery should be cached")
+	assert.Equal(t, 2, iam.Requests(fakeiam.TokenEndpoint))
+
+	request.Reload()
+	_, _ = request.Login(context.Background(), "testID", "testSecret", server.URL)
+	assert.Equal(t, 2, iam.Requests(fakeiam.DiscoveryEndpoint), "Reload should discover again")
+}
+
+func TestDiscoverFailures(t *testing.T) {
+	useDiscovery(t, "master")
+	for name, failure := range map[string]fakeiam.Failure{
+		"status":   {Status: http.StatusNotFound},
+		"body":     {Body: `not json`},
+		"endpoint": {Body: `{"token_endpoint":"/token"}`},
+		"issuer":   {Body: `{"issuer":"https://attacker.test/auth/realms/master","token_endpoint":"https://attacker.test/token"}`},
+	} {
+		server := fakeiam.NewServer()
+		server.Script(failure)
+
+		_, err := request.TokenURL(context.Background(), server.URL)
+
+		assert.NotNil(t, err, name)
+		switch name {
+		case "status":
+			var httpErr *request.HTTPError
+			assert.True(t, errors.As(err, &httpErr), "error should be an HTTPError")
+		case "issuer":
+			assert.ErrorIs(t, err, request.ErrIssuerMismatch)
+		default:
+			assert.ErrorIs(t, err, request.ErrDecode, name)
+		}
+		server.Close()
+	}
+}
+
+func TestDiscoverRejectsOtherContentTypes(t *testing.T) {
+	useDiscovery(t, "master")
+	// a login page served in place of the metadata, which the fake IAM cannot script
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`<html>Sign in</html>`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	_, err := request.TokenURL(context.Background(), server.URL)
+
+	assert.ErrorIs(t, err, request.ErrContentType)
+}
+
+func TestTokenURLWithoutDiscovery(t *testing.T) {
+	t.Setenv("IAM_REALM", "apps")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+
+	tokenURL, err := request.TokenURL(context.Background(), "https://iam.test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://iam.test/auth/realms/apps/protocol/openid-connect/token", tokenURL)
+	assert.Equal(t, "https://iam.test/auth/realms/apps/.well-known/openid-configuration",
+		request.DiscoveryURL("https://iam.test"))
+
+	issuer, err := request.Issuer(context.Background(), "https://iam.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://iam.test/auth/realms/apps", issuer)
+	jwksURL, err := request.JWKSURL(context.Background(), "https://iam.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://iam.test/auth/realms/apps/protocol/openid-connect/certs", jwksURL)
+}
+
+func TestDiscoveredIssuerAndJWKSURL(t *testing.T) {
+	useDiscovery(t, "master")
+	server := fakeiam.NewServer()
+	defer server.Close()
+
+	issuer, err := request.Issuer(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, server.RealmURL(), issuer)
+	jwksURL, err := request.JWKSURL(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, server.RealmURL()+fakeiam.JWKSEndpoint, jwksURL)
+}
@@ -0,0 +1,165 @@
+package request
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+)
+
+var (
+	// ErrTimeout the request did not complete before its deadline
+	ErrTimeout = errors.New("request timed out")
+	// ErrTLS the TLS handshake or the verification of the server certificate failed
+	ErrTLS = errors.New("TLS failure")
+	// ErrDecode the response body could not be decoded
+	ErrDecode = errors.New("response decoding failed")
+	// ErrResponseTooLarge the response body is larger than the configured maximum response size
+	ErrResponseTooLarge = errors.New("response too large")
+	// ErrContentType the response does not have the expected Content-Type
+	ErrContentType = errors.New("unexpected content type")
+	// ErrForeignLink a next link points to another scheme or host than the API
+	ErrForeignLink = errors.New("next link leaves the API")
+	// ErrIssuerMismatch the OpenID Provider Metadata is not the one of the realm it was fetched for
+	ErrIssuerMismatch = errors.New("issuer mismatch")
+)
+
+// maxErrorBodySize limits how much of an error response body is kept in an HTTPError
+const maxErrorBodySize = 1024
+
+// maxErrorMessageBodySize limits how much of the kept body is repeated in the error message
+const maxErrorMessageBodySize = 200
+
+// HTTPError is returned when the server answers with a status outside of 2xx
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body is truncated to the first 1KiB
+	Body []byte
+	// Endpoint is the requested URL without query or credentials
+	Endpoint string
+}
+
+func newHTTPError(endpoint string, resp *http.Response, body []byte) *HTTPError {
+	if len(body) > maxErrorBodySize {
+		body = body[:maxErrorBodySize]
+	}
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       body,
+		Endpoint:   redactEndpoint(endpoint),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Endpoint + " returned " + e.Status
+	if len(e.Body) > 0 {
+		body := e.Body
+		if len(body) > maxErrorMessageBodySize {
+			body = body[:maxErrorMessageBodySize]
+		}
+		msg += ": " + string(body)
+	}
+	return msg
+}
+
+// Retryable reports whether the same request may succeed when sent again
+func (e *HTTPError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryAfter returns the delay requested by the Retry-After header, given in seconds or as an HTTP date
+func (e *HTTPError) RetryAfter() (time.Duration, bool) {
+	value := e.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ErrorReason gives a low cardinality description of err, suitable as a metric label
+func ErrorReason(err error) string {
+	var httpErr *HTTPError
+	var oauthErr *OAuthError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, resilience.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.As(err, &oauthErr):
+		return "oauth_" + oauthErr.Code
+	case errors.As(err, &httpErr):
+		return "http_" + strconv.Itoa(httpErr.StatusCode)
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrTLS):
+		return "tls"
+	case errors.Is(err, ErrDecode):
+		return "decode"
+	case errors.Is(err, ErrResponseTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrContentType):
+		return "content_type"
+	}
+	return "other"
+}
+
+// classifyTransportError marks errors from sending a request or reading its response with
+// ErrTimeout or ErrTLS, so callers can use errors.Is without knowing the underlying types
+func classifyTransportError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	var certVerificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordHeaderErr) || errors.As(err, &certVerificationErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &certInvalidErr) || errors.As(err, &hostnameErr) {
+		return fmt.Errorf("%w: %w", ErrTLS, err)
+	}
+
+	return err
+}
+
+func redactEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
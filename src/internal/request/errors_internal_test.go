@@ -0,0 +1,97 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleFormRequestReturnsHTTPError(t *testing.T) {
+	t.Parallel()
+	body := strings.Repeat("x", 2*maxErrorBodySize)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Retry-After", "5")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte(body)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	_, err := HandleFormRequest(context.Background(), server.URL+"/token?secret=value", CreateFormData("testID", "testSecret"), http.Header{})
+
+	var httpErr *HTTPError
+	assert.True(t, errors.As(err, &httpErr), "error should be an HTTPError")
+	assert.Equal(t, http.StatusServiceUnavailable, httpErr.StatusCode)
+	assert.Equal(t, "5", httpErr.Header.Get("Retry-After"))
+	assert.Equal(t, server.URL+"/token", httpErr.Endpoint, "Endpoint should not contain the query")
+	assert.Len(t, httpErr.Body, maxErrorBodySize)
+	assert.True(t, httpErr.Retryable())
+	assert.Contains(t, err.Error(), "503 Service Unavailable: xxx")
+	assert.Less(t, len(err.Error()), maxErrorBodySize)
+}
+
+func TestHTTPErrorRetryable(t *testing.T) {
+	t.Parallel()
+	for status, retryable := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusForbidden:           false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusNotImplemented:      false,
+		http.StatusBadGateway:          true,
+		http.StatusGatewayTimeout:      true,
+	} {
+		err := &HTTPError{StatusCode: status}
+		assert.Equal(t, retryable, err.Retryable(), fmt.Sprintf("status %d", status))
+	}
+}
+
+func TestHandleFormRequestWithUntrustedCertificate(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	_, err := HandleFormRequest(context.Background(), server.URL, CreateFormData("testID", "testSecret"), http.Header{})
+
+	assert.ErrorIs(t, err, ErrTLS)
+	assert.Equal(t, "tls", ErrorReason(err))
+}
+
+func TestClassifyTransportErrorTimeout(t *testing.T) {
+	t.Parallel()
+	err := classifyTransportError(fmt.Errorf("Post: %w", context.DeadlineExceeded))
+
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "timeout", ErrorReason(err))
+}
+
+func TestLoginDecodeFailure(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`OK`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	_, err := Login(context.Background(), "testID", "testSecret", server.URL)
+
+	assert.ErrorIs(t, err, ErrDecode)
+	assert.Equal(t, "decode", ErrorReason(err))
+}
+
+func TestErrorReason(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "", ErrorReason(nil))
+	assert.Equal(t, "http_502", ErrorReason(fmt.Errorf("login: %w", &HTTPError{StatusCode: 502})))
+	assert.Equal(t, "oauth_invalid_client", ErrorReason(&OAuthError{Code: "invalid_client"}))
+	assert.Equal(t, "too_large", ErrorReason(fmt.Errorf("login: %w", ErrResponseTooLarge)))
+	assert.Equal(t, "content_type", ErrorReason(fmt.Errorf("login: %w", ErrContentType)))
+	assert.Equal(t, "other", ErrorReason(errors.New("unknown")))
+}
@@ -0,0 +1,200 @@
+package request
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// AccessTokenType identifies an OAuth 2.0 access token in a token exchange, see RFC 8693 section 3
+	AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+	// maxExchangedTokens bounds the token exchange cache, expired tokens are dropped first
+	maxExchangedTokens = 10000
+)
+
+// ExchangeRequest asks for a token acting on behalf of the subject of SubjectToken, as defined in RFC 8693
+type ExchangeRequest struct {
+	// SubjectToken is the token of the caller, usually the bearer token of an inbound request
+	SubjectToken string
+	// SubjectTokenType defaults to AccessTokenType
+	SubjectTokenType string
+	// Audience names the services the token is for, the IAM decides when it is empty
+	Audience []string
+	// Scopes requested for the token, the IAM decides when it is empty
+	Scopes []string
+}
+
+func (r ExchangeRequest) formData() url.Values {
+	formData := url.Values{}
+	formData.Set("grant_type", tokenExchangeGrantType)
+	formData.Set("subject_token", r.SubjectToken)
+	subjectTokenType := r.SubjectTokenType
+	if subjectTokenType == "" {
+		subjectTokenType = AccessTokenType
+	}
+	formData.Set("subject_token_type", subjectTokenType)
+	formData.Set("requested_token_type", AccessTokenType)
+	for _, audience := range r.Audience {
+		formData.Add("audience", audience)
+	}
+	if len(r.Scopes) > 0 {
+		formData.Set("scope", strings.Join(r.Scopes, " "))
+	}
+	return formData
+}
+
+// key identifies the request in the cache without keeping the subject token
+func (r ExchangeRequest) key() [sha256.Size]byte {
+	return sha256.Sum256([]byte(r.SubjectToken + "\x00" + r.SubjectTokenType + "\x00" +
+		strings.Join(r.Audience, " ") + "\x00" + strings.Join(r.Scopes, " ")))
+}
+
+// Exchange performs the token exchange grant of RFC 8693 at the token endpoint, authenticating the app
+// with its own client credentials
+func Exchange(ctx context.Context, clientID, clientSecret, baseURL string, r ExchangeRequest) (*Token, error) {
+	tokenURL, err := TokenURL(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := sendClientRequest(ctx, clientID, clientSecret, baseURL, tokenURL, r.formData())
+	if err != nil {
+		return nil, err
+	}
+	return parseToken(respBody)
+}
+
+type exchangedToken struct {
+	token      *Token
+	validUntil time.Time
+}
+
+// TokenExchanger caches the tokens returned by an exchange function per subject token, audience and scopes,
+// until shortly before they expire. Concurrent callers needing a new token for the same request share a single exchange.
+type TokenExchanger struct {
+	exchange func(ctx context.Context, r ExchangeRequest) (*Token, error)
+	now      func() time.Time
+
+	mu       sync.Mutex
+	tokens   map[[sha256.Size]byte]exchangedToken
+	inflight map[[sha256.Size]byte]*loginCall
+}
+
+// NewTokenExchanger Create a TokenExchanger obtaining its tokens from exchange
+func NewTokenExchanger(exchange func(ctx context.Context, r ExchangeRequest) (*Token, error)) *TokenExchanger {
+	return &TokenExchanger{
+		exchange: exchange,
+		now:      time.Now,
+		tokens:   map[[sha256.Size]byte]exchangedToken{},
+		inflight: map[[sha256.Size]byte]*loginCall{},
+	}
+}
+
+// Token returns the cached token for r while it is valid, otherwise it exchanges the subject token, or joins
+// the exchange of r already in progress. It returns early when ctx is done, the exchange is then cancelled
+// unless other callers wait for it.
+func (e *TokenExchanger) Token(ctx context.Context, r ExchangeRequest) (*Token, error) {
+	key := r.key()
+	e.mu.Lock()
+	if cached, ok := e.tokens[key]; ok && e.now().Before(cached.validUntil) {
+		e.mu.Unlock()
+		return cached.token, nil
+	}
+	call := e.inflight[key]
+	if call == nil {
+		exchangeCtx, cancel := detach(ctx)
+		call = &loginCall{done: make(chan struct{}), cancel: cancel}
+		e.inflight[key] = call
+		go e.runExchange(exchangeCtx, key, r, call)
+	}
+	call.waiters++
+	e.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		e.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if e.inflight[key] == call {
+				delete(e.inflight, key)
+			}
+		}
+		e.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (e *TokenExchanger) runExchange(ctx context.Context, key [sha256.Size]byte, r ExchangeRequest, call *loginCall) {
+	defer call.cancel()
+	token, err := e.exchange(ctx, r)
+
+	e.mu.Lock()
+	call.token, call.err = token, err
+	if err == nil {
+		e.store(key, token)
+	}
+	if e.inflight[key] == call {
+		delete(e.inflight, key)
+	}
+	e.mu.Unlock()
+	close(call.done)
+}
+
+// Invalidate drops the cached token for r
+func (e *TokenExchanger) Invalidate(r ExchangeRequest) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.tokens, r.key())
+}
+
+// OnBehalfOf returns the Tokens of an APIClient calling as the subject of r
+func (e *TokenExchanger) OnBehalfOf(r ExchangeRequest) Tokens {
+	return delegatedTokens{exchanger: e, request: r}
+}
+
+// store caches token for the request identified by key, the caller holds e.mu
+func (e *TokenExchanger) store(key [sha256.Size]byte, token *Token) {
+	lifetime := defaultTokenLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+	leeway := expiryLeeway
+	if leeway > lifetime/2 {
+		leeway = lifetime / 2
+	}
+	now := e.now()
+
+	if len(e.tokens) >= maxExchangedTokens {
+		for k, cached := range e.tokens {
+			if !now.Before(cached.validUntil) {
+				delete(e.tokens, k)
+			}
+		}
+		if len(e.tokens) >= maxExchangedTokens {
+			e.tokens = map[[sha256.Size]byte]exchangedToken{}
+		}
+	}
+	e.tokens[key] = exchangedToken{token: token, validUntil: now.Add(lifetime - leeway)}
+}
+
+// delegatedTokens are the Tokens of a single exchange request
+type delegatedTokens struct {
+	exchanger *TokenExchanger
+	request   ExchangeRequest
+}
+
+func (d delegatedTokens) Token(ctx context.Context) (*Token, error) {
+	return d.exchanger.Token(ctx, d.request)
+}
+
+func (d delegatedTokens) Invalidate() {
+	d.exchanger.Invalidate(d.request)
+}
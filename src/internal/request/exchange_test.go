@@ -0,0 +1,203 @@
+package request_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingExchange returns "<subject token>-1", "<subject token>-2"... for each exchange
+func countingExchange(exchanges *int32, expiresIn int) func(context.Context, request.ExchangeRequest) (*request.Token, error) {
+	return func(_ context.Context, r request.ExchangeRequest) (*request.Token, error) {
+		n := atomic.AddInt32(exchanges, 1)
+		return &request.Token{AccessToken: r.SubjectToken + "-" + strconv.Itoa(int(n)), ExpiresIn: expiresIn}, nil
+	}
+}
+
+func TestExchangeSendsTokenExchangeGrant(t *testing.T) {
+	iam := newRecordingIAM(t)
+	subjectToken := iam.IssueToken(map[string]any{"sub": "alice"})
+
+	token, err := request.Exchange(context.Background(), "testID", "testSecret", iam.URL, request.ExchangeRequest{
+		SubjectToken: subjectToken,
+		Audience:     []string{"inventory", "topology"},
+		Scopes:       []string{"read", "write"},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, request.AccessTokenType, token.IssuedTokenType)
+	assert.Equal(t, "read write", token.Scope)
+	parsed, err := jwt.Parse(token.AccessToken)
+	assert.Nil(t, err)
+	var claims jwt.Claims
+	assert.Nil(t, parsed.Claims(&claims))
+	assert.Equal(t, "alice", claims.Subject, "The token should act on behalf of the subject")
+	assert.Equal(t, jwt.Audience{"inventory", "topology"}, claims.Audience)
+
+	form := iam.forms(fakeiam.TokenEndpoint)[0]
+	assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", form.Get("grant_type"))
+	assert.Equal(t, subjectToken, form.Get("subject_token"))
+	assert.Equal(t, request.AccessTokenType, form.Get("subject_token_type"))
+	assert.Equal(t, request.AccessTokenType, form.Get("requested_token_type"))
+	assert.Equal(t, []string{"inventory", "topology"}, form["audience"])
+	assert.Equal(t, "read write", form.Get("scope"))
+	assert.Equal(t, "testID", form.Get("client_id"))
+	assert.Equal(t, "testSecret", form.Get("client_secret"))
+}
+
+func TestExchangeReturnsOAuthError(t *testing.T) {
+	iam := newRecordingIAM(t)
+
+	_, err := request.Exchange(context.Background(), "testID", "testSecret", iam.URL,
+		request.ExchangeRequest{SubjectToken: "not-issued-by-the-iam", Audience: []string{"inventory"}})
+
+	var oauthErr *request.OAuthError
+	assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+	assert.Equal(t, "invalid_request", oauthErr.Code)
+}
+
+func TestTokenExchangerCachesPerSubject(t *testing.T) {
+	t.Parallel()
+	var exchanges int32
+	exchanger := request.NewTokenExchanger(countingExchange(&exchanges, 300))
+	alice := request.ExchangeRequest{SubjectToken: "alice", Audience: []string{"inventory"}}
+	bob := request.ExchangeRequest{SubjectToken: "bob", Audience: []string{"inventory"}}
+
+	for i := 0; i < 2; i++ {
+		token, err := exchanger.Token(context.Background(), alice)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice-1", token.AccessToken)
+	}
+	token, err := exchanger.Token(context.Background(), bob)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob-2", token.AccessToken)
+	token, err = exchanger.Token(context.Background(), request.ExchangeRequest{SubjectToken: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice-3", token.AccessToken, "Another audience should be exchanged separately")
+
+	exchanger.Invalidate(alice)
+	token, err = exchanger.Token(context.Background(), alice)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice-4", token.AccessToken)
+}
+
+func TestTokenExchangerRenewsExpiredToken(t *testing.T) {
+	t.Parallel()
+	var exchanges int32
+	exchanger := request.NewTokenExchanger(countingExchange(&exchanges, 1))
+	alice := request.ExchangeRequest{SubjectToken: "alice"}
+
+	_, err := exchanger.Token(context.Background(), alice)
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		token, err := exchanger.Token(context.Background(), alice)
+		return err == nil && token.AccessToken == "alice-2"
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestTokenExchangerDeduplicatesConcurrentExchanges(t *testing.T) {
+	t.Parallel()
+	var exchanges int32
+	release := make(chan struct{})
+	exchange := countingExchange(&exchanges, 300)
+	exchanger := request.NewTokenExchanger(func(ctx context.Context, r request.ExchangeRequest) (*request.Token, error) {
+		<-release
+		return exchange(ctx, r)
+	})
+	alice := request.ExchangeRequest{SubjectToken: "alice", Audience: []string{"inventory"}}
+
+	var wg sync.WaitGroup
+	tokens := make([]*request.Token, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = exchanger.Token(context.Background(), alice)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&exchanges))
+	for _, token := range tokens {
+		assert.Equal(t, "alice-1", token.AccessToken)
+	}
+}
+
+func TestTokenExchangerCancelsExchangeWhenCallerGivesUp(t *testing.T) {
+	t.Parallel()
+	cancelled := make(chan error, 1)
+	exchanger := request.NewTokenExchanger(func(ctx context.Context, _ request.ExchangeRequest) (*request.Token, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := exchanger.Token(ctx, request.ExchangeRequest{SubjectToken: "alice"})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled, "The exchange nobody waits for should be cancelled")
+	case <-time.After(time.Second):
+		t.Fatal("The exchange was not cancelled")
+	}
+}
+
+func TestTokenExchangerDoesNotCacheFailures(t *testing.T) {
+	t.Parallel()
+	var exchanges int32
+	exchanger := request.NewTokenExchanger(func(context.Context, request.ExchangeRequest) (*request.Token, error) {
+		atomic.AddInt32(&exchanges, 1)
+		return nil, errors.New("denied")
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := exchanger.Token(context.Background(), request.ExchangeRequest{SubjectToken: "alice"})
+		assert.EqualError(t, err, "denied")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&exchanges))
+}
+
+func TestAPIClientCallsOnBehalfOfSubject(t *testing.T) {
+	t.Parallel()
+	var exchanges int32
+	var authorizations []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authorizations = append(authorizations, req.Header.Get("Authorization"))
+		if len(authorizations) == 1 {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	exchanger := request.NewTokenExchanger(countingExchange(&exchanges, 300))
+	api := request.NewAPIClient("items", server.URL,
+		exchanger.OnBehalfOf(request.ExchangeRequest{SubjectToken: "alice", Audience: []string{"items"}}))
+
+	err := api.Get(context.Background(), "/items", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Bearer alice-1", "Bearer alice-2"}, authorizations,
+		"A rejected delegated token should be exchanged again")
+}
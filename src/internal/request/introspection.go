@@ -0,0 +1,173 @@
+package request
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+)
+
+// maxIntrospections bounds the introspection cache, expired results are dropped first
+const maxIntrospections = 10000
+
+// Token type hints defined in RFC 7009 section 2.1
+const (
+	AccessTokenHint  = "access_token"
+	RefreshTokenHint = "refresh_token"
+)
+
+// Introspection is the introspection response defined in RFC 7662 section 2.2
+type Introspection struct {
+	Active    bool         `json:"active"`
+	Scope     string       `json:"scope,omitempty"`
+	ClientID  string       `json:"client_id,omitempty"`
+	Username  string       `json:"username,omitempty"`
+	TokenType string       `json:"token_type,omitempty"`
+	ExpiresAt int64        `json:"exp,omitempty"`
+	IssuedAt  int64        `json:"iat,omitempty"`
+	NotBefore int64        `json:"nbf,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  jwt.Audience `json:"aud,omitempty"`
+	Issuer    string       `json:"iss,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+	// Raw is the whole response, for the claims not listed above
+	Raw json.RawMessage `json:"-"`
+}
+
+type introspection struct {
+	result  *Introspection
+	expires time.Time
+}
+
+// introspected caches the results by token hash until they expire or Reload
+var (
+	introspectedMu sync.Mutex
+	introspected   = map[[sha256.Size]byte]introspection{}
+)
+
+func resetIntrospections() {
+	introspectedMu.Lock()
+	defer introspectedMu.Unlock()
+	introspected = map[[sha256.Size]byte]introspection{}
+}
+
+// cachedIntrospection returns the cached result for token, if it has not expired
+func cachedIntrospection(key [sha256.Size]byte, now time.Time) (*Introspection, bool) {
+	introspectedMu.Lock()
+	defer introspectedMu.Unlock()
+	entry, ok := introspected[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// cacheIntrospection keeps result for IAM_INTROSPECTION_CACHE_TTL, but not beyond the expiry of the token
+func cacheIntrospection(key [sha256.Size]byte, result *Introspection, now time.Time) {
+	expires := now.Add(configuration.Current().IamIntrospectionCacheTTL)
+	if result.Active && result.ExpiresAt != 0 && time.Unix(result.ExpiresAt, 0).Before(expires) {
+		expires = time.Unix(result.ExpiresAt, 0)
+	}
+	if !now.Before(expires) {
+		return
+	}
+
+	introspectedMu.Lock()
+	defer introspectedMu.Unlock()
+	if len(introspected) >= maxIntrospections {
+		for k, entry := range introspected {
+			if !now.Before(entry.expires) {
+				delete(introspected, k)
+			}
+		}
+		if len(introspected) >= maxIntrospections {
+			introspected = map[[sha256.Size]byte]introspection{}
+		}
+	}
+	introspected[key] = introspection{result: result, expires: expires}
+}
+
+func forgetIntrospection(token string) {
+	introspectedMu.Lock()
+	defer introspectedMu.Unlock()
+	delete(introspected, sha256.Sum256([]byte(token)))
+}
+
+// Introspect asks the IAM whether token is active and returns its claims, as defined in RFC 7662.
+// The app authenticates with its own client credentials. Results are cached for IAM_INTROSPECTION_CACHE_TTL.
+func Introspect(ctx context.Context, clientID, clientSecret, baseURL, token string) (*Introspection, error) {
+	key := sha256.Sum256([]byte(token))
+	if result, ok := cachedIntrospection(key, time.Now()); ok {
+		return result, nil
+	}
+
+	endpoint, err := IntrospectionURL(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := sendClientRequest(ctx, clientID, clientSecret, baseURL, endpoint,
+		url.Values{"token": {token}, "token_type_hint": {AccessTokenHint}})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Introspection{Raw: respBody}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return nil, fmt.Errorf("JSON Unmarshal Failed with following error: %w: %w", ErrDecode, err)
+	}
+	cacheIntrospection(key, result, time.Now())
+	return result, nil
+}
+
+// Revoke asks the IAM to revoke token, as defined in RFC 7009. tokenTypeHint is AccessTokenHint,
+// RefreshTokenHint or empty. A token the IAM does not know counts as revoked.
+func Revoke(ctx context.Context, clientID, clientSecret, baseURL, token, tokenTypeHint string) error {
+	endpoint, err := RevocationURL(ctx, baseURL)
+	if err != nil {
+		return err
+	}
+	params := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		params.Set("token_type_hint", tokenTypeHint)
+	}
+	if _, err := sendClientRequest(ctx, clientID, clientSecret, baseURL, endpoint, params); err != nil {
+		return err
+	}
+	forgetIntrospection(token)
+	return nil
+}
+
+// sendClientRequest posts params to an IAM endpoint with the client authentication of the configured method
+func sendClientRequest(ctx context.Context, clientID, clientSecret, baseURL, endpoint string, params url.Values,
+) ([]byte, error) {
+	if len(clientID) == 0 || (len(clientSecret) == 0 && needsClientSecret()) {
+		return nil, fmt.Errorf("Empty parameters provided for IamClientID or IamClientSecret")
+	}
+	// a client assertion is addressed to the token endpoint, which identifies the IAM
+	tokenURL, err := TokenURL(ctx, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := sendTokenRequest(ctx, endpoint, func() (url.Values, http.Header, error) {
+		formData := url.Values{}
+		for name, values := range params {
+			formData[name] = values
+		}
+		formData.Set("client_id", clientID)
+		formData.Set("client_secret", clientSecret)
+		headers := http.Header{}
+		return formData, headers, authenticate(formData, headers, clientID, clientSecret, tokenURL)
+	})
+	if err != nil {
+		return nil, asOAuthError(err)
+	}
+	return respBody, nil
+}
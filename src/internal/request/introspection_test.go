@@ -0,0 +1,181 @@
+package request_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+	"eric-oss-hello-world-go-app/src/internal/request"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingIAM is a fake IAM for the master realm, where testID is registered, which records the forms
+// posted to each endpoint
+type recordingIAM struct {
+	*fakeiam.IAM
+	URL string
+
+	mu    sync.Mutex
+	posts map[string][]url.Values
+}
+
+func newRecordingIAM(t *testing.T) *recordingIAM {
+	t.Helper()
+	t.Cleanup(request.Reload)
+	iam := &recordingIAM{posts: map[string][]url.Values{}}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			assert.Nil(t, req.ParseForm())
+			iam.mu.Lock()
+			endpoint := strings.TrimPrefix(req.URL.Path, "/auth/realms/master")
+			iam.posts[endpoint] = append(iam.posts[endpoint], req.PostForm)
+			iam.mu.Unlock()
+		}
+		iam.ServeHTTP(rw, req)
+	}))
+	t.Cleanup(server.Close)
+	iam.IAM = fakeiam.New(server.URL, "master")
+	iam.URL = server.URL
+	iam.AddClient("testID", fakeiam.Client{Secret: "testSecret"})
+	return iam
+}
+
+// forms returns the forms posted to endpoint, e.g. fakeiam.TokenEndpoint
+func (i *recordingIAM) forms(endpoint string) []url.Values {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]url.Values(nil), i.posts[endpoint]...)
+}
+
+func TestIntrospectCachesResult(t *testing.T) {
+	iam := newRecordingIAM(t)
+	token := iam.IssueToken(map[string]any{"sub": "user-1", "aud": "hello-world", "scope": "openid hello",
+		"realm_access": map[string]any{"roles": []string{"reader"}}})
+
+	for i := 0; i < 2; i++ {
+		result, err := request.Introspect(context.Background(), "testID", "testSecret", iam.URL, token)
+		assert.Nil(t, err)
+		assert.True(t, result.Active)
+		assert.Equal(t, "user-1", result.Subject)
+		assert.True(t, result.Audience.Contains("hello-world"))
+		assert.Greater(t, result.ExpiresAt, time.Now().Unix())
+		var extra struct {
+			RealmAccess struct {
+				Roles []string `json:"roles"`
+			} `json:"realm_access"`
+		}
+		assert.Nil(t, json.Unmarshal(result.Raw, &extra))
+		assert.Equal(t, []string{"reader"}, extra.RealmAccess.Roles)
+	}
+
+	introspected := iam.forms(fakeiam.IntrospectionEndpoint)
+	assert.Len(t, introspected, 1, "The result should be cached")
+	assert.Equal(t, token, introspected[0].Get("token"))
+	assert.Equal(t, request.AccessTokenHint, introspected[0].Get("token_type_hint"))
+	assert.Equal(t, "testID", introspected[0].Get("client_id"))
+	assert.Equal(t, "testSecret", introspected[0].Get("client_secret"))
+}
+
+func TestIntrospectDoesNotCacheBeyondTokenExpiry(t *testing.T) {
+	iam := newRecordingIAM(t)
+	// an IAM answering active for an expired token, the result must not outlive the token anyway
+	exp := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+	expired := fakeiam.Failure{Body: `{"active":true,"exp":` + exp + `}`}
+	iam.Script(expired, expired)
+
+	for i := 0; i < 2; i++ {
+		_, err := request.Introspect(context.Background(), "testID", "testSecret", iam.URL, "opaque")
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, iam.Requests(fakeiam.IntrospectionEndpoint))
+}
+
+func TestIntrospectWithoutCache(t *testing.T) {
+	t.Setenv("IAM_INTROSPECTION_CACHE_TTL", "0s")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+	iam := newRecordingIAM(t)
+
+	for i := 0; i < 2; i++ {
+		result, err := request.Introspect(context.Background(), "testID", "testSecret", iam.URL, "opaque")
+		assert.Nil(t, err)
+		assert.False(t, result.Active)
+	}
+
+	assert.Equal(t, 2, iam.Requests(fakeiam.IntrospectionEndpoint))
+}
+
+func TestIntrospectRejectsInvalidResponse(t *testing.T) {
+	iam := newRecordingIAM(t)
+	iam.Script(fakeiam.Failure{Body: `not json`})
+
+	_, err := request.Introspect(context.Background(), "testID", "testSecret", iam.URL, "opaque")
+
+	assert.ErrorIs(t, err, request.ErrDecode)
+}
+
+func TestRevokeForgetsIntrospection(t *testing.T) {
+	iam := newRecordingIAM(t)
+	token := iam.IssueToken(nil)
+
+	result, err := request.Introspect(context.Background(), "testID", "testSecret", iam.URL, token)
+	assert.Nil(t, err)
+	assert.True(t, result.Active)
+	err = request.Revoke(context.Background(), "testID", "testSecret", iam.URL, token, request.AccessTokenHint)
+	assert.Nil(t, err)
+	result, err = request.Introspect(context.Background(), "testID", "testSecret", iam.URL, token)
+	assert.Nil(t, err)
+	assert.False(t, result.Active, "A revoked token should be introspected again")
+
+	revoked := iam.forms(fakeiam.RevocationEndpoint)
+	assert.Len(t, revoked, 1)
+	assert.Equal(t, token, revoked[0].Get("token"))
+	assert.Equal(t, request.AccessTokenHint, revoked[0].Get("token_type_hint"))
+	assert.Equal(t, 2, iam.Requests(fakeiam.IntrospectionEndpoint))
+}
+
+func TestRevokeReturnsOAuthError(t *testing.T) {
+	iam := newRecordingIAM(t)
+	iam.Script(fakeiam.Failure{Status: http.StatusBadRequest, Body: `{"error":"unsupported_token_type"}`})
+
+	err := request.Revoke(context.Background(), "testID", "testSecret", iam.URL, "opaque", "")
+
+	var oauthErr *request.OAuthError
+	assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+	assert.Equal(t, "unsupported_token_type", oauthErr.Code)
+	assert.False(t, iam.forms(fakeiam.RevocationEndpoint)[0].Has("token_type_hint"))
+}
+
+func TestIntrospectionAndRevocationURLs(t *testing.T) {
+	introspectionURL, err := request.IntrospectionURL(context.Background(), "https://iam.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://iam.test/auth/realms/master/protocol/openid-connect/token/introspect", introspectionURL)
+	revocationURL, err := request.RevocationURL(context.Background(), "https://iam.test")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://iam.test/auth/realms/master/protocol/openid-connect/revoke", revocationURL)
+
+	useDiscovery(t, "master")
+	iam := newRecordingIAM(t)
+	revocationURL, err = request.RevocationURL(context.Background(), iam.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, iam.RealmURL()+fakeiam.RevocationEndpoint, revocationURL)
+
+	request.Reload()
+	realmURL := iam.RealmURL()
+	iam.Script(fakeiam.Failure{Body: `{"issuer":"` + realmURL + `","token_endpoint":"` + realmURL + fakeiam.TokenEndpoint + `"}`})
+	_, err = request.RevocationURL(context.Background(), iam.URL)
+	assert.ErrorIs(t, err, request.ErrDecode, "A realm without revocation endpoint should be reported")
+}
@@ -3,6 +3,7 @@ package request
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,51 +12,145 @@ import (
 	"strings"
 
 	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/httpclient"
 )
 
-// Token retrieved here
+// Token is the successful access token response defined in RFC 6749 section 5.1
 type Token struct {
-	AccessToken string `json:"accessToken"`
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+	// IssuedTokenType is set by a token exchange, see RFC 8693 section 2.2.1
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
-const loginPath = "/auth/realms/master/protocol/openid-connect/token"
+// Validate checks that the response actually carries a bearer token
+func (t *Token) Validate() error {
+	if t.AccessToken == "" {
+		return errors.New("Token response does not contain an access_token")
+	}
+	if t.TokenType != "" && !strings.EqualFold(t.TokenType, "bearer") {
+		return fmt.Errorf("Unsupported token_type %q in token response", t.TokenType)
+	}
+	return nil
+}
+
+// OAuthError is the error response defined in RFC 6749 section 5.2
+type OAuthError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	URI         string `json:"error_uri,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	msg := "OAuth2 error " + e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	return msg
+}
+
+// parseOAuthError returns the RFC 6749 error carried by body, or nil when body is not one
+func parseOAuthError(statusCode int, body []byte) *OAuthError {
+	var oauthErr OAuthError
+	if err := json.Unmarshal(body, &oauthErr); err != nil || oauthErr.Code == "" {
+		return nil
+	}
+	oauthErr.StatusCode = statusCode
+	return &oauthErr
+}
+
+// asOAuthError returns the RFC 6749 error carried by an HTTPError, otherwise err itself
+func asOAuthError(err error) error {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if oauthErr := parseOAuthError(httpErr.StatusCode, httpErr.Body); oauthErr != nil {
+			return oauthErr
+		}
+	}
+	return err
+}
+
+// client is shared by all requests so connections to the IAM are pooled
+var client = httpclient.New(configuration.IamTLSConfig)
+
+// HTTPClient returns the pooled client used for requests to the IAM
+func HTTPClient() *http.Client {
+	return client.HTTPClient()
+}
+
+// Reload rebuilds the pooled client from the current CA certificate and timeouts,
+// resets the circuit breakers with the current settings and forgets the discovered endpoints
+// and the cached introspection results
+func Reload() {
+	client.Reload()
+	resetBreakers()
+	resetDiscovery()
+	resetIntrospections()
+}
+
+// LoginURL Returns the token endpoint of the configured realm for the given IAM base URL
+func LoginURL(baseURL string) string {
+	return baseURL + path.Join("/", configuration.Current().TokenPath())
+}
 
 // HandleLogin Creates an instance of the request body
-func HandleLogin(clientID, clientSecret, baseURL string) error {
-	loginURL := baseURL + path.Join(loginPath)
+func HandleLogin(ctx context.Context, clientID, clientSecret, baseURL string) error {
+	_, err := Login(ctx, clientID, clientSecret, baseURL)
+	return err
+}
 
-	if len(clientID) == 0 || len(clientSecret) == 0 {
-		return fmt.Errorf("Empty parameters provided for IamClientID or IamClientSecret")
+// Login performs the client credentials flow and returns the token.
+// Retries and the wait between them stop when ctx is done.
+func Login(ctx context.Context, clientID, clientSecret, baseURL string) (*Token, error) {
+	if len(clientID) == 0 || (len(clientSecret) == 0 && needsClientSecret()) {
+		return nil, fmt.Errorf("Empty parameters provided for IamClientID or IamClientSecret")
+	}
+	loginURL, err := TokenURL(ctx, baseURL)
+	if err != nil {
+		return nil, err
 	}
-	formData := CreateFormData(clientID, clientSecret)
 
-	respBody, err := HandleFormRequest(loginURL, formData, http.Header{})
+	respBody, err := sendTokenRequest(ctx, loginURL, func() (url.Values, http.Header, error) {
+		formData := CreateFormData(clientID, clientSecret)
+		headers := http.Header{}
+		return formData, headers, authenticate(formData, headers, clientID, clientSecret, loginURL)
+	})
 	if err != nil {
-		return err
+		return nil, asOAuthError(err)
 	}
+	return parseToken(respBody)
+}
+
+// parseToken decodes and validates a successful token response
+func parseToken(respBody []byte) (*Token, error) {
 	var token Token
 	if err := json.Unmarshal(respBody, &token); err != nil {
-		return fmt.Errorf("JSON Unmarshal Failed with following error: %w", err)
+		return nil, fmt.Errorf("JSON Unmarshal Failed with following error: %w: %w", ErrDecode, err)
+	}
+	if token.AccessToken == "" {
+		if oauthErr := parseOAuthError(http.StatusOK, respBody); oauthErr != nil {
+			return nil, oauthErr
+		}
+	}
+	if err := token.Validate(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &token, nil
 }
 
-// HandleFormRequest for Client Credential Flow Login
-func HandleFormRequest(endpoint string, formData url.Values, headers http.Header) ([]byte, error) {
-	// Create a new TLS config with the server's CA cert
-	tlsConfig := configuration.NewTLSConfig()
-
-	// Create an HTTP client with the custom TLS config
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
-
+// HandleFormRequest for Client Credential Flow Login, the request is abandoned when ctx is done
+func HandleFormRequest(ctx context.Context, endpoint string, formData url.Values, headers http.Header) ([]byte, error) {
 	// Create a new http.Request object
-	req, err := http.NewRequestWithContext(context.Background(),
-		http.MethodPost, endpoint, strings.NewReader(formData.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(formData.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("Create http.Request object failed: %w", err)
 	}
@@ -66,53 +161,82 @@ func HandleFormRequest(endpoint string, formData url.Values, headers http.Header
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Make the request to the specified endpoint
-	resp, err := client.Do(req)
+	return send(req)
+}
+
+// send makes the request and returns the response body, or an HTTPError for a status outside of 2xx
+func send(req *http.Request) ([]byte, error) {
+	_, respBody, err := do(req, iamMaxResponseSize())
+	return respBody, err
+}
+
+// do is send also returning the response, whose body has been read and closed. A body larger than
+// maxSize fails with ErrResponseTooLarge, and one that is not JSON when req accepts only JSON with ErrContentType.
+func do(req *http.Request, maxSize int64) (*http.Response, []byte, error) {
+	resp, err := open(req)
 	if err != nil {
-		return nil, fmt.Errorf("Request Failed with following error: %w", err)
+		return resp, nil, err
 	}
 	defer resp.Body.Close() //nolint:errcheck //error has no impact
 
 	// Read the response body
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err := ReadBody(resp, maxSize)
 	if err != nil {
-		return nil, fmt.Errorf("Reading response body failed: %w", err)
-	}
-
-	// Check the response status code
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, &httpError{
-			statusCode: resp.StatusCode,
-			statusText: resp.Status,
-			body:       respBody,
-		}
+		return resp, nil, err
 	}
 
 	// If the response body is empty, return nil
 	if len(respBody) == 0 {
-		return nil, nil
+		return resp, nil, nil
+	}
+	if acceptsOnlyJSON(req) {
+		if err := CheckJSON(resp); err != nil {
+			return resp, nil, err
+		}
 	}
 
 	// Return the response body
-	return respBody, nil
+	return resp, respBody, nil
 }
 
-// CreateFormData Creates a formData Map that will be used with HandleFormRequest
+// open makes the request and returns the response with its body still to be read and closed by the caller.
+// For a status outside of 2xx it returns an HTTPError, the body being already closed.
+func open(req *http.Request) (*http.Response, error) {
+	// Make the request to the specified endpoint
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Request Failed with following error: %w", classifyTransportError(err))
+	}
+
+	// Check the response status code
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close() //nolint:errcheck //error has no impact
+		// only the start of an error body is kept, the rest is not worth reading
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		if err != nil {
+			return resp, fmt.Errorf("Reading response body failed: %w", classifyTransportError(err))
+		}
+		return resp, newHTTPError(req.URL.String(), resp, respBody)
+	}
+	return resp, nil
+}
+
+// CreateFormData Creates a formData Map that will be used with HandleFormRequest,
+// with the tenant, scopes and audience from the configuration
 func CreateFormData(clientID, clientSecret string) url.Values {
+	config := configuration.Current()
 	formData := url.Values{}
 	formData.Set("grant_type", "client_credentials")
 	formData.Set("client_id", clientID)
 	formData.Set("client_secret", clientSecret)
-	formData.Set("tenant_id", "master")
+	if tenant := config.IamTenant; tenant != "" {
+		formData.Set("tenant_id", tenant)
+	}
+	if scopes := config.IamScopes; len(scopes) > 0 {
+		formData.Set("scope", strings.Join(scopes, " "))
+	}
+	if audience := config.IamAudience; audience != "" {
+		formData.Set("audience", audience)
+	}
 	return formData
 }
-
-type httpError struct {
-	statusCode int
-	statusText string
-	body       []byte
-}
-
-func (e *httpError) Error() string {
-	return e.statusText
-}
@@ -1,11 +1,15 @@
 package request_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
 	"eric-oss-hello-world-go-app/src/internal/request"
 
 	"github.com/stretchr/testify/assert"
@@ -16,14 +20,14 @@ var formData = request.CreateFormData("ClientID", "ClientSecret")
 func TestHandleLoginWithInvalidURL(t *testing.T) {
 	// when invalid baseURL is passed, we are expecting the request to fail
 	t.Parallel()
-	err := request.HandleLogin("testID", "testSecret", "")
+	err := request.HandleLogin(context.Background(), "testID", "testSecret", "")
 	assert.Contains(t, err.Error(), "Request Failed with following error: ")
 }
 
 func TestHandleLoginWithEmptyFormDataParameters(t *testing.T) {
 	// when empty ClientID or ClientSecret is passed, we are expecting the request to fail
 	t.Parallel()
-	err := request.HandleLogin("", "", "")
+	err := request.HandleLogin(context.Background(), "", "", "")
 	assert.Contains(t, err.Error(), "Empty parameters provided for IamClientID or IamClientSecret")
 }
 
@@ -39,19 +43,125 @@ func TestHandleLoginWithJSON(t *testing.T) {
 	}))
 	defer server.Close()
 
-	err := request.HandleLogin("testID", "testSecret", server.URL)
+	err := request.HandleLogin(context.Background(), "testID", "testSecret", server.URL)
 	assert.Nil(t, err, "HandleLogin should return nil")
 }
 
+func TestLoginReturnsToken(t *testing.T) {
+	// when server returns token, we are expecting it to be returned
+	t.Parallel()
+	server := fakeiam.NewServer()
+	defer server.Close()
+	server.AddClient("testID", fakeiam.Client{Secret: "testSecret"})
+
+	token, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+	assert.Nil(t, err, "Login should return nil error")
+	assert.NotEmpty(t, token.AccessToken)
+	assert.Equal(t, 300, token.ExpiresIn)
+	assert.Equal(t, "Bearer", token.TokenType)
+}
+
+func TestLoginParsesFullTokenResponse(t *testing.T) {
+	// all RFC 6749 fields should be mapped
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"access_token":"testToken","token_type":"bearer","expires_in":300,` + //nolint:errcheck //mock server
+			`"refresh_token":"refresh","refresh_expires_in":1800,"scope":"openid profile"}`))
+	}))
+	defer server.Close()
+
+	token, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, request.Token{
+		AccessToken: "testToken", TokenType: "bearer", ExpiresIn: 300,
+		RefreshToken: "refresh", RefreshExpiresIn: 1800, Scope: "openid profile",
+	}, *token)
+}
+
+func TestLoginWithoutAccessToken(t *testing.T) {
+	// a response that parses but carries no token should not be treated as a successful login
+	t.Parallel()
+	tests := []struct {
+		name     string
+		response string
+		error    string
+	}{
+		{name: "legacy field name", response: `{"accessToken":"testToken"}`, error: "does not contain an access_token"},
+		{name: "empty object", response: `{}`, error: "does not contain an access_token"},
+		{name: "unsupported type", response: `{"access_token":"testToken","token_type":"mac"}`, error: "Unsupported token_type"},
+	}
+
+	for _, testParameters := range tests {
+		response := testParameters.response
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.Write([]byte(response)) //nolint:errcheck //mock server, no error handling required
+		}))
+
+		_, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+		server.Close()
+
+		assert.NotNil(t, err, testParameters.name)
+		assert.Contains(t, err.Error(), testParameters.error, testParameters.name)
+	}
+}
+
+func TestLoginReturnsOAuthError(t *testing.T) {
+	// RFC 6749 error responses should be returned as OAuthError
+	t.Parallel()
+	server := fakeiam.NewServer()
+	defer server.Close()
+	server.AddClient("testID", fakeiam.Client{Secret: "otherSecret"})
+
+	_, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+
+	var oauthErr *request.OAuthError
+	assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+	assert.Equal(t, http.StatusUnauthorized, oauthErr.StatusCode)
+	assert.Equal(t, "invalid_client", oauthErr.Code)
+	assert.Equal(t, "Invalid client credentials", oauthErr.Description)
+	assert.Equal(t, "OAuth2 error invalid_client: Invalid client credentials (status 401)", err.Error())
+}
+
+func TestLoginWithNonOAuthErrorResponse(t *testing.T) {
+	// error responses that are not RFC 6749 errors keep the HTTP status
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := request.Login(context.Background(), "testID", "testSecret", server.URL)
+
+	var oauthErr *request.OAuthError
+	assert.False(t, errors.As(err, &oauthErr))
+	assert.Contains(t, err.Error(), "502")
+}
+
 func TestHandleLoginWithoutJSON(t *testing.T) {
-	// when server does not return JSON, we are expecting JSON Unmarshal error
+	// when server does not return JSON, we are expecting a content type error
 	t.Parallel()
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		rw.Write([]byte(`OK`)) //nolint:errcheck //mock server, no error handling required
 	}))
 	defer server.Close()
 
-	err := request.HandleLogin("testID", "testSecret", server.URL)
+	err := request.HandleLogin(context.Background(), "testID", "testSecret", server.URL)
+	assert.ErrorIs(t, err, request.ErrContentType)
+	assert.Contains(t, err.Error(), `returned Content-Type "text/plain; charset=utf-8" instead of JSON`)
+}
+
+func TestHandleLoginWithInvalidJSON(t *testing.T) {
+	// when server returns JSON that does not parse, we are expecting JSON Unmarshal error
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`OK`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	err := request.HandleLogin(context.Background(), "testID", "testSecret", server.URL)
 	assert.Contains(t, err.Error(), "JSON Unmarshal Failed with following error: ")
 }
 
@@ -63,7 +173,7 @@ func TestHandleFormRequestWithResponse(t *testing.T) {
 		rw.Write(testResponse) //nolint:errcheck //mock server, no error handling required
 	}))
 	defer server.Close()
-	resp, _ := request.HandleFormRequest(server.URL, formData, http.Header{})
+	resp, _ := request.HandleFormRequest(context.Background(), server.URL, formData, http.Header{})
 	assert.Equal(t, resp, testResponse)
 }
 
@@ -73,7 +183,7 @@ func TestHandleFormRequestWithNoResponse(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 	}))
 	defer server.Close()
-	resp, _ := request.HandleFormRequest(server.URL, formData, http.Header{})
+	resp, _ := request.HandleFormRequest(context.Background(), server.URL, formData, http.Header{})
 	assert.Equal(t, len(resp), 0)
 }
 
@@ -85,7 +195,7 @@ func TestHandleFormRequestWithIncorrectStatusCode(t *testing.T) {
 	}))
 	defer server.Close()
 
-	_, err := request.HandleFormRequest(server.URL, formData, http.Header{})
+	_, err := request.HandleFormRequest(context.Background(), server.URL, formData, http.Header{})
 	assert.Contains(t, err.Error(), "403")
 }
 
@@ -100,3 +210,24 @@ func TestGetFormDataReturnsCorrectValues(t *testing.T) {
 	assert.Equal(t, testFormData.Get("client_id"), testID)
 	assert.Equal(t, testFormData.Get("client_secret"), testSecret)
 }
+
+func TestLoginURL(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "https://iam.test/auth/realms/master/protocol/openid-connect/token",
+		request.LoginURL("https://iam.test"))
+}
+
+func TestCreateFormDataUsesConfiguration(t *testing.T) {
+	t.Setenv("IAM_TENANT", "tenant1")
+	t.Setenv("IAM_SCOPES", "openid, profile")
+	t.Setenv("IAM_AUDIENCE", "api")
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+
+	testFormData := request.CreateFormData("testID", "testSecret")
+
+	assert.Equal(t, "tenant1", testFormData.Get("tenant_id"))
+	assert.Equal(t, "openid profile", testFormData.Get("scope"))
+	assert.Equal(t, "api", testFormData.Get("audience"))
+}
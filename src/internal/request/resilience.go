@@ -0,0 +1,148 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	log "eric-oss-hello-world-go-app/src/internal/logging"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+)
+
+// Hooks are notified of the outbound calls of the package, the server uses them to export metrics
+type Hooks struct {
+	// OnRetry is called before a request to the IAM is sent again
+	OnRetry func(reason string)
+	// OnStateChange is called when the circuit breaker of an IAM endpoint changes state, and with the closed
+	// state when the breaker is created. endpoint is the last element of the endpoint path, such as "token".
+	OnStateChange func(endpoint string, state resilience.State)
+	// OnAPICall is called after every call of an APIClient, status is 0 when no response was received
+	OnAPICall func(target, method string, status int, duration time.Duration, err error)
+}
+
+var hooks atomic.Pointer[Hooks]
+
+// SetHooks replaces the hooks called for outbound calls
+func SetHooks(h Hooks) {
+	hooks.Store(&h)
+}
+
+// breakers holds a circuit breaker per token endpoint, they are dropped by Reload
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*resilience.Breaker{}
+)
+
+func breaker(endpoint string) *resilience.Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	if b, ok := breakers[endpoint]; ok {
+		return b
+	}
+	config := configuration.Current()
+	b := resilience.NewBreaker(config.IamBreakerThreshold, config.IamBreakerOpenTimeout)
+	b.IsFailure = retryable
+	name := endpointName(endpoint)
+	b.OnStateChange = func(state resilience.State) {
+		log.WithFields(log.Fields{"endpoint": redactEndpoint(endpoint), "state": state.String()}).
+			Warning("Circuit breaker changed state")
+		reportState(name, state)
+	}
+	breakers[endpoint] = b
+	reportState(name, resilience.StateClosed)
+	return b
+}
+
+func reportState(name string, state resilience.State) {
+	if h := hooks.Load(); h != nil && h.OnStateChange != nil {
+		h.OnStateChange(name, state)
+	}
+}
+
+// endpointName returns a short name of an IAM endpoint which stays the same across realms and hosts,
+// the last element of its path
+func endpointName(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "unknown"
+	}
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+	return "root"
+}
+
+func resetBreakers() {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	breakers = map[string]*resilience.Breaker{}
+}
+
+func retryPolicy() resilience.RetryPolicy {
+	config := configuration.Current()
+	return resilience.RetryPolicy{
+		MaxAttempts:    config.IamRetryMaxAttempts,
+		InitialBackoff: config.IamRetryInitialBackoff,
+		MaxBackoff:     config.IamRetryMaxBackoff,
+		Retryable:      retryable,
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			log.WithFields(log.Fields{"attempt": attempt, "wait": wait.String()}).WithError(err).
+				Warning("IAM request failed, retrying")
+			if h := hooks.Load(); h != nil && h.OnRetry != nil {
+				h.OnRetry(ErrorReason(err))
+			}
+		},
+	}
+}
+
+// retryable reports whether a token request failing with err may succeed when sent again.
+// The client credentials grant has no side effect, so any failure of the network or of the
+// server is retried, but not a rejection of the request itself.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrTLS) || errors.Is(err, ErrDecode) ||
+		errors.Is(err, ErrResponseTooLarge) || errors.Is(err, ErrContentType) {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Retryable()
+	}
+	var opErr *net.OpError
+	return errors.Is(err, ErrTimeout) || errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sendTokenRequest posts the form returned by newForm to an IAM endpoint, such as the token endpoint,
+// through the circuit breaker of the endpoint, retrying transient failures with a new form.
+// A response that is not JSON or larger than IAM_MAX_RESPONSE_SIZE is not retried.
+func sendTokenRequest(ctx context.Context, endpoint string,
+	newForm func() (url.Values, http.Header, error),
+) ([]byte, error) {
+	var respBody []byte
+	err := breaker(endpoint).Execute(func() error {
+		return retryPolicy().Do(ctx, func(ctx context.Context) error {
+			formData, headers, err := newForm()
+			if err != nil {
+				return err
+			}
+			// the IAM endpoints answer JSON, anything else is refused
+			headers.Set("Accept", "application/json")
+			respBody, err = HandleFormRequest(ctx, endpoint, formData, headers)
+			return err
+		})
+	})
+	if errors.Is(err, resilience.ErrCircuitOpen) {
+		return nil, fmt.Errorf("Request to %s not sent: %w", redactEndpoint(endpoint), err)
+	}
+	return respBody, err
+}
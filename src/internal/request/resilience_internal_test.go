@@ -0,0 +1,174 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setResilienceConfig(t *testing.T, maxAttempts, threshold string) {
+	t.Setenv("IAM_RETRY_MAX_ATTEMPTS", maxAttempts)
+	t.Setenv("IAM_RETRY_INITIAL_BACKOFF", "1ms")
+	t.Setenv("IAM_RETRY_MAX_BACKOFF", "1s")
+	t.Setenv("IAM_BREAKER_THRESHOLD", threshold)
+	original := configuration.Current()
+	configuration.ReloadAppConfig()
+	t.Cleanup(func() { configuration.SetAppConfig(original) })
+}
+
+func TestLoginRetriesTransientFailures(t *testing.T) {
+	setResilienceConfig(t, "3", "5")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&calls, 1) < 3 {
+			rw.Header().Set("Retry-After", "0")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte(`{"access_token":"token","expires_in":300}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+	var retries []string
+	SetHooks(Hooks{OnRetry: func(reason string) { retries = append(retries, reason) }})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+
+	token, err := Login(context.Background(), "testID", "testSecret", server.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"http_503", "http_503"}, retries)
+}
+
+func TestLoginDoesNotRetryRejectedCredentials(t *testing.T) {
+	setResilienceConfig(t, "3", "1")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusUnauthorized)
+		rw.Write([]byte(`{"error":"invalid_client"}`)) //nolint:errcheck //mock server, no error handling required
+	}))
+	defer server.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := Login(context.Background(), "testID", "testSecret", server.URL)
+		var oauthErr *OAuthError
+		assert.True(t, errors.As(err, &oauthErr), "error should be an OAuthError")
+	}
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Rejections should neither be retried nor open the breaker")
+}
+
+func TestLoginOpensCircuitBreaker(t *testing.T) {
+	setResilienceConfig(t, "2", "2")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	var states []string
+	SetHooks(Hooks{OnStateChange: func(endpoint string, state resilience.State) {
+		states = append(states, endpoint+" "+state.String())
+	}})
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+	t.Cleanup(resetBreakers)
+
+	for i := 0; i < 2; i++ {
+		_, err := Login(context.Background(), "testID", "testSecret", server.URL)
+		assert.Equal(t, "http_502", ErrorReason(err))
+	}
+	_, err := Login(context.Background(), "testID", "testSecret", server.URL)
+
+	assert.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	assert.Equal(t, "circuit_open", ErrorReason(err))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls), "No request should be sent while the breaker is open")
+	assert.Equal(t, []string{"token closed", "token open"}, states)
+
+	Reload()
+	_, err = Login(context.Background(), "testID", "testSecret", server.URL)
+	assert.Equal(t, "http_502", ErrorReason(err), "Reload should reset the circuit breakers")
+}
+
+func TestHTTPErrorRetryAfter(t *testing.T) {
+	t.Parallel()
+	for value, expected := range map[string]time.Duration{
+		"":                              -1,
+		"120":                           120 * time.Second,
+		"-1":                            -1,
+		"soon":                          -1,
+		"Wed, 21 Oct 2015 07:28:00 GMT": 0,
+	} {
+		err := &HTTPError{Header: http.Header{"Retry-After": []string{value}}}
+		delay, ok := err.RetryAfter()
+		if expected < 0 {
+			assert.False(t, ok, value)
+			continue
+		}
+		assert.True(t, ok, value)
+		assert.Equal(t, expected, delay, value)
+	}
+
+	delay, ok := (&HTTPError{Header: http.Header{"Retry-After": []string{
+		time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}}).RetryAfter()
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, delay, float64(2*time.Second))
+}
+
+func TestLoginStopsRetryingWhenContextIsDone(t *testing.T) {
+	setResilienceConfig(t, "10", "5")
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.Header().Set("Retry-After", "1")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Login(ctx, "testID", "testSecret", server.URL)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHandleFormRequestIsAbandonedWithContext(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err := HandleFormRequest(ctx, server.URL, CreateFormData("testID", "testSecret"), http.Header{})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "canceled", ErrorReason(err))
+	assert.False(t, retryable(err))
+}
+
+func TestEndpointName(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "token", endpointName("https://iam.test/auth/realms/master/protocol/openid-connect/token"))
+	assert.Equal(t, "introspect",
+		endpointName("https://iam.test/auth/realms/apps/protocol/openid-connect/token/introspect?x=1"))
+	assert.Equal(t, "root", endpointName("https://iam.test/"))
+	assert.Equal(t, "unknown", endpointName("://iam"))
+}
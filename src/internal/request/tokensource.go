@@ -0,0 +1,197 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "eric-oss-hello-world-go-app/src/internal/logging"
+)
+
+const (
+	// defaultTokenLifetime is assumed when the token response has no expires_in
+	defaultTokenLifetime = 60 * time.Second
+	// expiryLeeway stops handing out a token shortly before it expires, so it is still valid when it is used
+	expiryLeeway = 5 * time.Second
+	// refreshRatio of the token lifetime after which the background refresh logs in again
+	refreshRatio = 0.8
+
+	minRefreshRetry = time.Second
+	maxRefreshRetry = time.Minute
+)
+
+// TokenSource caches the token returned by a login function and refreshes it before it expires.
+// Concurrent callers needing a new token share a single login.
+type TokenSource struct {
+	login func(ctx context.Context) (*Token, error)
+	now   func() time.Time
+
+	mu          sync.Mutex
+	token       *Token
+	validUntil  time.Time
+	nextRefresh time.Time
+	retry       time.Duration
+	inflight    *loginCall
+}
+
+// loginCall is a login or token exchange shared by the callers waiting for it, it is cancelled when they all give up
+type loginCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	token   *Token
+	err     error
+}
+
+// NewTokenSource Create a TokenSource obtaining its tokens from login
+func NewTokenSource(login func(ctx context.Context) (*Token, error)) *TokenSource {
+	return &TokenSource{login: login, now: time.Now, retry: minRefreshRetry}
+}
+
+// Token returns the cached token while it is valid, otherwise it logs in.
+// It returns early when ctx is done, the login is then cancelled unless other callers wait for it.
+func (s *TokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	if s.token != nil && s.now().Before(s.validUntil) {
+		token := s.token
+		s.mu.Unlock()
+		return token, nil
+	}
+	s.mu.Unlock()
+
+	return s.refresh(ctx)
+}
+
+// Invalidate drops the cached token, so the next call to Token logs in again
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = nil
+	s.nextRefresh = time.Time{}
+}
+
+// Drop removes the cached token and returns it, or nil, so it can be revoked
+func (s *TokenSource) Drop() *Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := s.token
+	s.token = nil
+	s.nextRefresh = time.Time{}
+	return token
+}
+
+// Start refreshes the token in the background ahead of its expiry until ctx is cancelled,
+// so callers of Token do not wait for a login
+func (s *TokenSource) Start(ctx context.Context) {
+	go func() {
+		for {
+			timer := time.NewTimer(s.untilRefresh())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			if _, err := s.refresh(ctx); err != nil && ctx.Err() == nil {
+				log.WithError(err).Warning("Background token refresh failed")
+			}
+		}
+	}()
+}
+
+func (s *TokenSource) untilRefresh() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextRefresh.Sub(s.now())
+}
+
+// refresh logs in, or joins the login already in progress. The login does not run in ctx, which
+// belongs to the first caller only, but keeps its deadline. A token stored by a login that completed
+// since the caller looked is returned instead, unless it is due for a refresh.
+func (s *TokenSource) refresh(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	if now := s.now(); s.token != nil && now.Before(s.validUntil) && now.Before(s.nextRefresh) {
+		token := s.token
+		s.mu.Unlock()
+		return token, nil
+	}
+	call := s.inflight
+	if call == nil {
+		loginCtx, cancel := detach(ctx)
+		call = &loginCall{done: make(chan struct{}), cancel: cancel}
+		s.inflight = call
+		go s.runLogin(loginCtx, call)
+	}
+	call.waiters++
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			call.cancel()
+			if s.inflight == call {
+				s.inflight = nil
+			}
+		}
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// detach returns a context that is not cancelled with ctx but has the same deadline
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(context.Background(), deadline)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (s *TokenSource) runLogin(ctx context.Context, call *loginCall) {
+	defer call.cancel()
+	token, err := s.login(ctx)
+
+	s.mu.Lock()
+	call.token, call.err = token, err
+	// a login cancelled because nobody waits for it anymore says nothing about the IAM
+	if err == nil || !errors.Is(ctx.Err(), context.Canceled) {
+		s.store(token, err)
+	}
+	if s.inflight == call {
+		s.inflight = nil
+	}
+	s.mu.Unlock()
+	close(call.done)
+}
+
+// store caches a successful login, after a failure the next background refresh backs off.
+// The caller holds s.mu.
+func (s *TokenSource) store(token *Token, err error) {
+	now := s.now()
+	if err != nil {
+		s.nextRefresh = now.Add(s.retry)
+		s.retry *= 2
+		if s.retry > maxRefreshRetry {
+			s.retry = maxRefreshRetry
+		}
+		return
+	}
+
+	lifetime := defaultTokenLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+	leeway := expiryLeeway
+	if leeway > lifetime/2 {
+		leeway = lifetime / 2
+	}
+	s.token = token
+	s.validUntil = now.Add(lifetime - leeway)
+	s.nextRefresh = now.Add(time.Duration(float64(lifetime) * refreshRatio))
+	s.retry = minRefreshRetry
+}
@@ -0,0 +1,290 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock lets tests move the TokenSource through a token's lifetime
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func countingLogin(calls *int32, expiresIn int) func(context.Context) (*Token, error) {
+	return func(context.Context) (*Token, error) {
+		n := atomic.AddInt32(calls, 1)
+		return &Token{AccessToken: string(rune('a' + n - 1)), ExpiresIn: expiresIn}, nil
+	}
+}
+
+func TestTokenSourceCachesToken(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	clock := &fakeClock{now: time.Now()}
+	source := NewTokenSource(countingLogin(&calls, 300))
+	source.now = clock.Now
+
+	first, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	clock.Advance(290 * time.Second)
+	second, err := source.Token(context.Background())
+	assert.Nil(t, err)
+
+	assert.Same(t, first, second, "Token should be reused until it is about to expire")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	clock.Advance(6 * time.Second)
+	third, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "b", third.AccessToken, "Token should be renewed within the expiry leeway")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestTokenSourceLogsInAtIAM(t *testing.T) {
+	t.Parallel()
+	server := fakeiam.NewServer()
+	defer server.Close()
+	server.AddClient("testID", fakeiam.Client{Secret: "testSecret"})
+	source := NewTokenSource(func(ctx context.Context) (*Token, error) {
+		return Login(ctx, "testID", "testSecret", server.URL)
+	})
+
+	first, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	second, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, 1, server.Requests(fakeiam.TokenEndpoint))
+
+	source.Invalidate()
+	third, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.AccessToken, third.AccessToken, "An invalidated token should be replaced")
+	assert.Equal(t, 2, server.Requests(fakeiam.TokenEndpoint))
+}
+
+func TestTokenSourceUsesDefaultLifetime(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	clock := &fakeClock{now: time.Now()}
+	source := NewTokenSource(countingLogin(&calls, 0))
+	source.now = clock.Now
+
+	_, _ = source.Token(context.Background())
+	clock.Advance(defaultTokenLifetime - expiryLeeway - time.Second)
+	_, _ = source.Token(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	clock.Advance(2 * time.Second)
+	_, _ = source.Token(context.Background())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestTokenSourceDeduplicatesConcurrentLogins(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	release := make(chan struct{})
+	source := NewTokenSource(func(context.Context) (*Token, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Token{AccessToken: "shared", ExpiresIn: 300}, nil
+	})
+
+	var wg sync.WaitGroup
+	tokens := make([]*Token, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = source.Token(context.Background())
+		}(i)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, token := range tokens {
+		assert.Equal(t, "shared", token.AccessToken)
+	}
+}
+
+func TestTokenSourceReusesTokenStoredByCompletedLogin(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	clock := &fakeClock{now: time.Now()}
+	source := NewTokenSource(countingLogin(&calls, 300))
+	source.now = clock.Now
+	first, err := source.Token(context.Background())
+	assert.Nil(t, err)
+
+	// a caller that found no valid token before the login above stored one
+	second, err := source.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.Same(t, first, second, "A token stored since the caller looked should not be logged in again")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	clock.Advance(240 * time.Second)
+	third, err := source.refresh(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "b", third.AccessToken, "A token due for a refresh should be logged in again")
+}
+
+func TestTokenSourceDoesNotCacheFailures(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	source := NewTokenSource(func(context.Context) (*Token, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, errors.New("login failed")
+		}
+		return &Token{AccessToken: "recovered", ExpiresIn: 300}, nil
+	})
+
+	_, err := source.Token(context.Background())
+	assert.NotNil(t, err)
+
+	token, err := source.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "recovered", token.AccessToken)
+}
+
+func TestTokenSourceInvalidate(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	source := NewTokenSource(countingLogin(&calls, 300))
+
+	_, _ = source.Token(context.Background())
+	source.Invalidate()
+	token, _ := source.Token(context.Background())
+
+	assert.Equal(t, "b", token.AccessToken)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestTokenSourceDrop(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	source := NewTokenSource(countingLogin(&calls, 300))
+
+	assert.Nil(t, source.Drop(), "Nothing should be dropped before a login")
+	first, _ := source.Token(context.Background())
+	assert.Same(t, first, source.Drop())
+	assert.Nil(t, source.Drop())
+	token, _ := source.Token(context.Background())
+
+	assert.Equal(t, "b", token.AccessToken)
+}
+
+func TestTokenSourceRefreshesInBackground(t *testing.T) {
+	t.Parallel()
+	var calls int32
+	// a one second lifetime is refreshed every 800ms
+	source := NewTokenSource(countingLogin(&calls, 1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source.Start(ctx)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) >= 2 }, 5*time.Second, 10*time.Millisecond,
+		"Token should be logged in at start and refreshed ahead of expiry")
+	cancel()
+}
+
+func TestTokenSourceBacksOffAfterFailures(t *testing.T) {
+	t.Parallel()
+	clock := &fakeClock{now: time.Now()}
+	source := NewTokenSource(func(context.Context) (*Token, error) { return nil, errors.New("login failed") })
+	source.now = clock.Now
+
+	_, _ = source.refresh(context.Background())
+	assert.Equal(t, minRefreshRetry, source.untilRefresh())
+	_, _ = source.refresh(context.Background())
+	assert.Equal(t, 2*minRefreshRetry, source.untilRefresh())
+	for i := 0; i < 10; i++ {
+		_, _ = source.refresh(context.Background())
+	}
+	assert.Equal(t, maxRefreshRetry, source.untilRefresh())
+}
+
+func TestTokenSourceCancelsLoginWhenCallerGivesUp(t *testing.T) {
+	t.Parallel()
+	loginCancelled := make(chan struct{})
+	source := NewTokenSource(func(ctx context.Context) (*Token, error) {
+		<-ctx.Done()
+		close(loginCancelled)
+		return nil, ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := source.Token(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	select {
+	case <-loginCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Login should be cancelled once its only caller gave up")
+	}
+}
+
+func TestTokenSourceKeepsSharedLoginForRemainingCallers(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	var loginErr atomic.Value
+	source := NewTokenSource(func(ctx context.Context) (*Token, error) {
+		select {
+		case <-release:
+			return &Token{AccessToken: "shared", ExpiresIn: 300}, nil
+		case <-ctx.Done():
+			loginErr.Store(ctx.Err())
+			return nil, ctx.Err()
+		}
+	})
+	leaving, leave := context.WithCancel(context.Background())
+	result := make(chan *Token)
+	go func() {
+		token, _ := source.Token(context.Background())
+		result <- token
+	}()
+	assert.Eventually(t, func() bool {
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		return source.inflight != nil && source.inflight.waiters == 1
+	}, time.Second, time.Millisecond)
+
+	go func() {
+		_, _ = source.Token(leaving)
+	}()
+	assert.Eventually(t, func() bool {
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		return source.inflight.waiters == 2
+	}, time.Second, time.Millisecond)
+	leave()
+	close(release)
+
+	assert.Equal(t, "shared", (<-result).AccessToken)
+	assert.Nil(t, loginErr.Load(), "Login should not be cancelled while a caller still waits for it")
+}
@@ -0,0 +1,143 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the service while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State of a circuit breaker
+type State int
+
+const (
+	// StateClosed calls go through, consecutive failures are counted
+	StateClosed State = iota
+	// StateOpen calls are rejected until the open timeout has passed
+	StateOpen
+	// StateHalfOpen a single trial call decides whether to close or open again
+	StateHalfOpen
+)
+
+// States lists every state, in the order of their values
+var States = []State{StateClosed, StateOpen, StateHalfOpen}
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	}
+	return "unknown"
+}
+
+// Breaker stops calling a service after repeated failures, giving it time to recover
+type Breaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	// IsFailure reports whether an error counts against the service, when nil every error does.
+	// An error that does not count, such as a cancelled call, leaves the failure count and the state as they are.
+	IsFailure func(error) bool
+	// OnStateChange is called with the new state, while the breaker is locked
+	OnStateChange func(State)
+
+	now      func() time.Time
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker Create a closed Breaker which opens after failureThreshold consecutive failures,
+// and lets a trial call through once openTimeout has passed
+func NewBreaker(failureThreshold int, openTimeout time.Duration) *Breaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &Breaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Execute calls fn unless the breaker is open, and records its outcome
+func (b *Breaker) Execute(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err)
+	return err
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		b.setState(StateHalfOpen)
+	}
+	switch {
+	case b.state == StateOpen, b.state == StateHalfOpen && b.probing:
+		return ErrCircuitOpen
+	case b.state == StateHalfOpen:
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.probing = false
+		b.setState(StateClosed)
+	case b.IsFailure != nil && !b.IsFailure(err):
+		// the call tells nothing about the service, only the trial slot is given back
+		b.probing = false
+	case b.state == StateHalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.failures = 0
+	b.probing = false
+	b.openedAt = b.now()
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	if b.OnStateChange != nil {
+		b.OnStateChange(state)
+	}
+}
@@ -0,0 +1,117 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+func newTestBreaker(now *time.Time) (*Breaker, *[]State) {
+	var transitions []State
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return *now }
+	b.OnStateChange = func(state State) { transitions = append(transitions, state) }
+	return b, &transitions
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b, transitions := newTestBreaker(&now)
+	fail := func() error { return errUnavailable }
+
+	assert.ErrorIs(t, b.Execute(fail), errUnavailable)
+	assert.Nil(t, b.Execute(func() error { return nil }), "A success should reset the failure count")
+	assert.ErrorIs(t, b.Execute(fail), errUnavailable)
+	assert.Equal(t, StateClosed, b.State())
+	assert.ErrorIs(t, b.Execute(fail), errUnavailable)
+
+	assert.Equal(t, StateOpen, b.State())
+	called := false
+	err := b.Execute(func() error { called = true; return nil })
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.False(t, called, "No call should be made while open")
+	assert.Equal(t, []State{StateOpen}, *transitions)
+}
+
+func TestBreakerClosesAfterSuccessfulTrial(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b, transitions := newTestBreaker(&now)
+	_ = b.Execute(func() error { return errUnavailable })
+	_ = b.Execute(func() error { return errUnavailable })
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, StateHalfOpen, b.State())
+	err := b.Execute(func() error {
+		assert.ErrorIs(t, b.Execute(func() error { return nil }), ErrCircuitOpen,
+			"Only one trial call should be let through")
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateClosed}, *transitions)
+}
+
+func TestBreakerReopensAfterFailedTrial(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b, transitions := newTestBreaker(&now)
+	_ = b.Execute(func() error { return errUnavailable })
+	_ = b.Execute(func() error { return errUnavailable })
+
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, b.Execute(func() error { return errUnavailable }), errUnavailable)
+
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen}, *transitions)
+}
+
+func TestBreakerIgnoresErrorsThatAreNotFailures(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b, _ := newTestBreaker(&now)
+	b.IsFailure = func(err error) bool { return errors.Is(err, errUnavailable) }
+	rejected := errors.New("rejected")
+
+	for i := 0; i < 5; i++ {
+		assert.ErrorIs(t, b.Execute(func() error { return rejected }), rejected)
+	}
+	assert.Equal(t, StateClosed, b.State())
+
+	_ = b.Execute(func() error { return errUnavailable })
+	_ = b.Execute(func() error { return rejected })
+	_ = b.Execute(func() error { return errUnavailable })
+	assert.Equal(t, StateOpen, b.State(), "An error that is not a failure should not reset the failure count")
+}
+
+func TestBreakerStaysHalfOpenAfterCancelledTrial(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	b, transitions := newTestBreaker(&now)
+	b.IsFailure = func(err error) bool { return !errors.Is(err, context.Canceled) }
+	_ = b.Execute(func() error { return errUnavailable })
+	_ = b.Execute(func() error { return errUnavailable })
+
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, b.Execute(func() error { return context.Canceled }), context.Canceled)
+
+	assert.Equal(t, StateHalfOpen, b.State(), "A cancelled trial should not close the breaker")
+	assert.Equal(t, []State{StateOpen, StateHalfOpen}, *transitions)
+	assert.Nil(t, b.Execute(func() error { return nil }), "Another trial call should be let through")
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestStateString(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "closed", StateClosed.String())
+	assert.Equal(t, "open", StateOpen.String())
+	assert.Equal(t, "half_open", StateHalfOpen.String())
+	assert.Equal(t, "unknown", State(42).String())
+}
@@ -0,0 +1,78 @@
+// Package resilience provides retry policies and circuit breakers for calls to other services
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how often and how long to wait before a failed call is sent again
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, values below 1 mean a single attempt
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable reports whether a call that failed with the error may be sent again,
+	// when nil no error is retried
+	Retryable func(error) bool
+	// OnRetry is called before waiting for the next attempt
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// retryAfterError is implemented by errors carrying the delay requested by the server
+type retryAfterError interface {
+	RetryAfter() (time.Duration, bool)
+}
+
+// Backoff returns the wait before the given retry, counted from 1, as a random duration
+// between zero and the exponential backoff capped at MaxBackoff
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)) //nolint:gosec //jitter does not need a secure source
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable, the attempts are exhausted
+// or ctx is done.
+// A delay requested by the server is waited for instead of the backoff, unless it exceeds MaxBackoff,
+// in which case the last error is returned straight away.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || ctx.Err() != nil || attempt >= p.MaxAttempts || p.Retryable == nil || !p.Retryable(err) {
+			return err
+		}
+
+		wait := p.Backoff(attempt)
+		var retryAfter retryAfterError
+		if errors.As(err, &retryAfter) {
+			if delay, ok := retryAfter.RetryAfter(); ok {
+				if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+					return err
+				}
+				wait = delay
+			}
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
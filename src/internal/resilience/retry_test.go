@@ -0,0 +1,132 @@
+package resilience_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+type retryAfterErr time.Duration
+
+func (e retryAfterErr) Error() string { return "retry later" }
+
+func (e retryAfterErr) RetryAfter() (time.Duration, bool) { return time.Duration(e), true }
+
+func retryTransient(err error) bool {
+	return errors.Is(err, errTransient) || errors.As(err, new(retryAfterErr))
+}
+
+func TestRetryPolicyRetriesUntilSuccess(t *testing.T) {
+	t.Parallel()
+	var retries []int
+	policy := resilience.RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Retryable:      retryTransient,
+		OnRetry:        func(attempt int, err error, wait time.Duration) { retries = append(retries, attempt) },
+	}
+	calls := 0
+
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []int{1, 2}, retries)
+}
+
+func TestRetryPolicyStopsAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+	policy := resilience.RetryPolicy{MaxAttempts: 3, Retryable: retryTransient}
+	calls := 0
+
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errTransient
+	})
+
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicyDoesNotRetryPermanentErrors(t *testing.T) {
+	t.Parallel()
+	policy := resilience.RetryPolicy{MaxAttempts: 3, Retryable: retryTransient}
+	calls := 0
+
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errors.New("permanent")
+	})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicyRespectsRetryAfter(t *testing.T) {
+	t.Parallel()
+	var waits []time.Duration
+	policy := resilience.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Second,
+		Retryable:      retryTransient,
+		OnRetry:        func(attempt int, err error, wait time.Duration) { waits = append(waits, wait) },
+	}
+	calls := 0
+
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return retryAfterErr(10 * time.Millisecond)
+		}
+		return retryAfterErr(time.Minute)
+	})
+
+	assert.Equal(t, []time.Duration{10 * time.Millisecond}, waits)
+	assert.Equal(t, 2, calls, "A Retry-After beyond MaxBackoff should not be waited for")
+	assert.Equal(t, retryAfterErr(time.Minute), err)
+}
+
+func TestRetryPolicyStopsWhenContextIsDone(t *testing.T) {
+	t.Parallel()
+	policy := resilience.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+		Retryable:      retryTransient,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := policy.Do(ctx, func(ctx context.Context) error { return errTransient })
+
+	assert.ErrorIs(t, err, errTransient)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	policy := resilience.RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, policy.Backoff(1), 100*time.Millisecond)
+		assert.LessOrEqual(t, policy.Backoff(3), 400*time.Millisecond)
+		assert.LessOrEqual(t, policy.Backoff(10), time.Second)
+		assert.GreaterOrEqual(t, policy.Backoff(10), time.Duration(0))
+	}
+	assert.Equal(t, time.Duration(0), resilience.RetryPolicy{}.Backoff(1))
+}
@@ -0,0 +1,78 @@
+// Package watcher polls files for changes, following the symlinks Kubernetes swaps when it updates a mounted volume
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Watcher calls onChange whenever one of its files is created, removed, modified or re-linked
+type Watcher struct {
+	paths       []string
+	interval    time.Duration
+	onChange    func()
+	fingerprint string
+}
+
+// New Create a Watcher for paths, taking the current state of the files as the starting point
+func New(interval time.Duration, onChange func(), paths ...string) *Watcher {
+	w := &Watcher{
+		paths:    paths,
+		interval: interval,
+		onChange: onChange,
+	}
+	w.fingerprint = w.currentFingerprint()
+	return w
+}
+
+// Run polls the files until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if w.Poll() {
+				w.onChange()
+			}
+		}
+	}
+}
+
+// Poll reports whether the files changed since the previous poll
+func (w *Watcher) Poll() bool {
+	fingerprint := w.currentFingerprint()
+	if fingerprint == w.fingerprint {
+		return false
+	}
+	w.fingerprint = fingerprint
+	return true
+}
+
+// currentFingerprint describes every file by the target it resolves to, its size and modification time.
+// Kubernetes updates a ConfigMap or Secret volume by re-pointing the ..data symlink to a new directory,
+// so the resolved path changes even when the new file has the same size and timestamp.
+func (w *Watcher) currentFingerprint() string {
+	var b strings.Builder
+	for _, path := range w.paths {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:missing;", path)
+			continue
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			fmt.Fprintf(&b, "%s:missing;", path)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%s:%d:%d;", path, resolved, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
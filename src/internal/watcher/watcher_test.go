@@ -0,0 +1,88 @@
+package watcher_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/watcher"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollWithoutChanges(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cert.pem")
+	assert.Nil(t, os.WriteFile(file, []byte("cert"), 0o600))
+
+	w := watcher.New(time.Second, func() {}, file)
+
+	assert.False(t, w.Poll())
+}
+
+func TestPollDetectsModifiedFile(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cert.pem")
+	assert.Nil(t, os.WriteFile(file, []byte("cert"), 0o600))
+	w := watcher.New(time.Second, func() {}, file)
+
+	assert.Nil(t, os.WriteFile(file, []byte("new cert"), 0o600))
+
+	assert.True(t, w.Poll())
+	assert.False(t, w.Poll(), "Change should only be reported once")
+}
+
+func TestPollDetectsCreatedAndRemovedFile(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cert.pem")
+	w := watcher.New(time.Second, func() {}, file)
+
+	assert.Nil(t, os.WriteFile(file, []byte("cert"), 0o600))
+	assert.True(t, w.Poll())
+
+	assert.Nil(t, os.Remove(file))
+	assert.True(t, w.Poll())
+}
+
+func TestPollDetectsKubernetesSymlinkSwap(t *testing.T) {
+	t.Parallel()
+	// lay out the volume the way the kubelet does: file -> ..data/file, ..data -> ..timestamped dir
+	dir := t.TempDir()
+	for _, version := range []string{"..2024_01_01", "..2024_01_02"} {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, version), 0o700))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, version, "cert.pem"), []byte("cert"), 0o600))
+	}
+	sameTime := time.Now().Add(-time.Hour)
+	for _, version := range []string{"..2024_01_01", "..2024_01_02"} {
+		assert.Nil(t, os.Chtimes(filepath.Join(dir, version, "cert.pem"), sameTime, sameTime))
+	}
+	assert.Nil(t, os.Symlink("..2024_01_01", filepath.Join(dir, "..data")))
+	assert.Nil(t, os.Symlink(filepath.Join("..data", "cert.pem"), filepath.Join(dir, "cert.pem")))
+	w := watcher.New(time.Second, func() {}, filepath.Join(dir, "cert.pem"))
+
+	assert.Nil(t, os.Symlink("..2024_01_02", filepath.Join(dir, "..data_tmp")))
+	assert.Nil(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	assert.True(t, w.Poll())
+}
+
+func TestRunCallsOnChange(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cert.pem")
+	assert.Nil(t, os.WriteFile(file, []byte("cert"), 0o600))
+	changed := make(chan struct{}, 1)
+	w := watcher.New(time.Millisecond, func() { changed <- struct{}{} }, file)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	assert.Nil(t, os.WriteFile(file, []byte("new cert"), 0o600))
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange was not called")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/healthcheck"
+	log "eric-oss-hello-world-go-app/src/internal/logging"
+	"eric-oss-hello-world-go-app/src/internal/request"
+)
+
+const (
+	dependencyCheckTimeout  = 2 * time.Second
+	dependencyCheckCacheTTL = 10 * time.Second
+)
+
+// registerHealthChecks adds the app's checks to the registry. The checks read the
+// current configuration when they run, so they follow configuration reloads.
+func registerHealthChecks(registry *healthcheck.Registry) {
+	registry.Register(healthcheck.NewChecker("shutdown", checkNotShuttingDown),
+		healthcheck.Options{CacheTTL: -1}, healthcheck.Readiness)
+
+	registry.Register(healthcheck.NewChecker("config", checkConfig),
+		healthcheck.Options{}, healthcheck.Startup, healthcheck.Readiness)
+
+	registry.Register(healthcheck.NewChecker("certificates", checkCertificates),
+		healthcheck.Options{}, healthcheck.Startup, healthcheck.Readiness)
+
+	registry.Register(healthcheck.NewChecker("iam", checkIam),
+		healthcheck.Options{Timeout: dependencyCheckTimeout, CacheTTL: dependencyCheckCacheTTL}, healthcheck.Readiness)
+
+	// remote logging is best effort, an unreachable log endpoint is reported without failing readiness
+	registry.Register(healthcheck.NewChecker("log-endpoint", checkLogEndpoint),
+		healthcheck.Options{Timeout: dependencyCheckTimeout, CacheTTL: dependencyCheckCacheTTL, Optional: true},
+		healthcheck.Readiness)
+}
+
+func checkNotShuttingDown(ctx context.Context) error {
+	if shuttingDown.Load() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+func checkConfig(ctx context.Context) error {
+	return configuration.Current().Validate()
+}
+
+func checkCertificates(ctx context.Context) error {
+	config := configuration.Current()
+	if config.CaCertFileName != "" {
+		if configuration.NewTLSConfig() == nil {
+			return errors.New("cannot load CA certificate " + path.Join(config.CaCertFilePath, config.CaCertFileName))
+		}
+	}
+	if config.LocalProtocol == "https" {
+		if err := healthcheck.CheckFiles(config.CertFile, config.KeyFile); err != nil {
+			return err
+		}
+		if reloader := certReloader; reloader != nil {
+			if err := reloader.Check(time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+	if config.LogEndpoint != "" && configuration.LogmTLSConfig() == nil {
+		return errors.New("cannot load log client certificate from " + config.AppCertFilePath)
+	}
+	switch config.IamClientAuthMethod {
+	case configuration.PrivateKeyJWT, configuration.TLSClientAuth:
+		if _, err := configuration.AppKeyPair(); err != nil {
+			return fmt.Errorf("cannot load the app key pair for IAM client authentication: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkIam(ctx context.Context) error {
+	config := configuration.Current()
+	tokenURL, err := request.TokenURL(ctx, config.IamBaseURL)
+	if err != nil {
+		return err
+	}
+	return healthcheck.CheckHTTP(ctx, request.HTTPClient(), tokenURL)
+}
+
+func checkLogEndpoint(ctx context.Context) error {
+	config := configuration.Current()
+	if config.LogEndpoint == "" {
+		return nil
+	}
+	client := log.HTTPClient()
+	if client == nil {
+		return errors.New("remote logging is not initialized")
+	}
+	return healthcheck.CheckHTTP(ctx, client, "https://"+config.LogEndpoint)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/healthcheck"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func componentStatus(report healthcheck.Report, name string) string {
+	for _, component := range report.Components {
+		if component.Name == name {
+			return component.Status
+		}
+	}
+	return ""
+}
+
+func TestProbesReportDependencies(t *testing.T) {
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer iam.Close()
+
+	useConfig(t, &configuration.Config{
+		LocalProtocol:   "http",
+		IamClientID:     "testID",
+		IamClientSecret: "testSecret",
+		IamBaseURL:      iam.URL,
+	})
+
+	registry := healthcheck.NewRegistry()
+	registerHealthChecks(registry)
+
+	assert.Equal(t, healthcheck.StatusUp, registry.Check(context.Background(), healthcheck.Liveness).Status)
+	assert.Equal(t, healthcheck.StatusUp, registry.Check(context.Background(), healthcheck.Startup).Status)
+
+	report := registry.Check(context.Background(), healthcheck.Readiness)
+	assert.Equal(t, healthcheck.StatusUp, report.Status)
+	assert.Equal(t, healthcheck.StatusUp, componentStatus(report, "iam"))
+	assert.Equal(t, healthcheck.StatusUp, componentStatus(report, "config"))
+}
+
+func TestProbesFailWithInvalidConfig(t *testing.T) {
+	useConfig(t, &configuration.Config{
+		LocalProtocol: "https",
+		CertFile:      "missing-certificate.pem",
+		KeyFile:       "missing-key.pem",
+		IamBaseURL:    "http://127.0.0.1:0",
+	})
+
+	registry := healthcheck.NewRegistry()
+	registerHealthChecks(registry)
+
+	report := registry.Check(context.Background(), healthcheck.Startup)
+	assert.Equal(t, healthcheck.StatusDown, report.Status)
+	assert.Equal(t, healthcheck.StatusDown, componentStatus(report, "config"))
+	assert.Equal(t, healthcheck.StatusDown, componentStatus(report, "certificates"))
+
+	report = registry.Check(context.Background(), healthcheck.Readiness)
+	assert.Equal(t, healthcheck.StatusDown, componentStatus(report, "iam"))
+}
+
+func TestReadinessFailsWhileShuttingDown(t *testing.T) {
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	registry := healthcheck.NewRegistry()
+	registerHealthChecks(registry)
+
+	report := registry.Check(context.Background(), healthcheck.Readiness)
+	assert.Equal(t, healthcheck.StatusDown, componentStatus(report, "shutdown"))
+	assert.Equal(t, healthcheck.StatusUp, registry.Check(context.Background(), healthcheck.Liveness).Status)
+}
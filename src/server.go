@@ -2,41 +2,159 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"eric-oss-hello-world-go-app/src/internal/auth"
 	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/healthcheck"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
 	log "eric-oss-hello-world-go-app/src/internal/logging"
 	"eric-oss-hello-world-go-app/src/internal/metric"
 	"eric-oss-hello-world-go-app/src/internal/request"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
+	"eric-oss-hello-world-go-app/src/internal/watcher"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
-	config     = configuration.AppConfig
-	server     *http.Server
-	ExitSignal chan os.Signal
+	server       *http.Server
+	ExitSignal   chan os.Signal
+	ReloadSignal chan os.Signal
+	shuttingDown atomic.Bool
+	certReloader *configuration.CertReloader
+	clientCAs    atomic.Pointer[x509.CertPool]
+	tokenSource  = request.NewTokenSource(login)
+	bearerKeys   = auth.NewKeySet(fetchSigningKeys)
+	healthChecks = healthcheck.NewRegistry()
+)
+
+// exit codes returned by the process once it has been asked to terminate
+const (
+	exitOK             = 0
+	exitShutdownFailed = 1
+	exitForced         = 2
 )
 
 func init() {
 	log.Init()
+	// code using log/slog, third-party packages included, logs through the same pipeline
+	slog.SetDefault(slog.New(log.NewSlogHandler()))
 	log.Info("Go Hello World Sample App initializing...")
 	ExitSignal = getExitSignal()
+	ReloadSignal = getReloadSignal()
 	metric.SetupMetrics()
+	recordBreakerState("log_endpoint", resilience.StateClosed)
+	request.SetHooks(request.Hooks{
+		OnRetry: func(reason string) {
+			metric.UpstreamRetriesTotal.WithLabelValues("iam", reason).Inc()
+		},
+		OnStateChange: func(endpoint string, state resilience.State) { recordBreakerState("iam_"+endpoint, state) },
+		OnAPICall:     recordAPICall,
+	})
+	log.SetHooks(log.Hooks{
+		OnEntries: func(outcome string, n int) {
+			metric.LogEntriesTotal.WithLabelValues(outcome).Add(float64(n))
+		},
+		OnSpool: func(entries int, bytes int64) {
+			metric.LogSpoolSize.WithLabelValues("entries").Set(float64(entries))
+			metric.LogSpoolSize.WithLabelValues("bytes").Set(float64(bytes))
+		},
+		OnStateChange: func(state resilience.State) { recordBreakerState("log_endpoint", state) },
+		OnLevelChange: recordLogLevel,
+	})
+	recordLogLevel(log.Severity(log.Level()))
+	registerHealthChecks(healthChecks)
 }
 
-func hello(resp http.ResponseWriter, req *http.Request) {
+// recordBreakerState sets the state of the circuit breaker of target in metric.CircuitBreakerState
+func recordBreakerState(target string, state resilience.State) {
+	for _, s := range resilience.States {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		metric.CircuitBreakerState.WithLabelValues(target, s.String()).Set(value)
+	}
+}
 
-	metric.RequestsTotal.Inc()
+// recordLogLevel sets the current log level in metric.LogLevel
+func recordLogLevel(severity string) {
+	for _, s := range log.Severities {
+		value := 0.0
+		if s == severity {
+			value = 1
+		}
+		metric.LogLevel.WithLabelValues(s).Set(value)
+	}
+}
 
-	err := request.HandleLogin(config.IamClientID, config.IamClientSecret, config.IamBaseURL)
+// recordAPICall counts a call of a request.APIClient in the upstream metrics
+func recordAPICall(target, method string, status int, duration time.Duration, err error) {
+	code := strconv.Itoa(status)
+	if status == 0 {
+		code = request.ErrorReason(err)
+	}
+	metric.UpstreamRequestsTotal.WithLabelValues(target, method, code).Inc()
+	metric.UpstreamRequestDurationSeconds.WithLabelValues(target, method).Observe(duration.Seconds())
 	if err != nil {
-		log.Error("login failed: " + err.Error())
+		metric.UpstreamFailuresTotal.WithLabelValues(target, request.ErrorReason(err)).Inc()
+	}
+}
+
+// login reads the credentials from the current configuration when called, so they follow configuration reloads.
+// The login, retries included, is bounded by the configured request timeout.
+func login(ctx context.Context) (*request.Token, error) {
+	config := configuration.Current()
+	if config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.RequestTimeout)
+		defer cancel()
+	}
+	return request.Login(ctx, config.IamClientID, config.IamClientSecret, config.IamBaseURL)
+}
+
+// fetchSigningKeys gets the keys the IAM signs its tokens with, bounded by the configured request timeout
+func fetchSigningKeys(ctx context.Context) (*jwt.JWKS, error) {
+	config := configuration.Current()
+	if config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.RequestTimeout)
+		defer cancel()
+	}
+	jwksURL, err := request.JWKSURL(ctx, config.IamBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	jwks := &jwt.JWKS{}
+	if err := request.FetchJSON(ctx, jwksURL, jwks); err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
+
+func hello(resp http.ResponseWriter, req *http.Request) {
+	_, err := tokenSource.Token(req.Context())
+	if err != nil && req.Context().Err() != nil {
+		log.WithContext(req.Context()).WithError(err).Debug("Client went away during login")
+		return
+	}
+	if err != nil {
+		metric.UpstreamFailuresTotal.WithLabelValues("iam", request.ErrorReason(err)).Inc()
+		log.WithContext(req.Context()).WithError(err).Error("login failed")
 	}
 
 	_, err = fmt.Fprintf(resp, "Hello World!!")
@@ -44,10 +162,28 @@ func hello(resp http.ResponseWriter, req *http.Request) {
 		log.Error("Error writing to response")
 	}
 
+	if claims, ok := auth.TokenClaimsFromContext(req.Context()); ok {
+		log.WithContext(req.Context()).WithField("caller", claims.String()).Info("Hello World!!")
+		return
+	}
+	if identity, ok := auth.ClientIdentityFromContext(req.Context()); ok {
+		log.WithContext(req.Context()).WithField("client", identity.String()).Info("Hello World!!")
+		return
+	}
 	log.Info("Hello World!!")
 }
 
 func health(resp http.ResponseWriter, req *http.Request) {
+	if shuttingDown.Load() {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		_, err := fmt.Fprintf(resp, "Shutting down")
+		if err != nil {
+			log.Error("Error writing to response")
+		}
+		log.Debug("Health check: Shutting down")
+		return
+	}
+
 	_, err := fmt.Fprintf(resp, "Ok")
 	if err != nil {
 		log.Error("Error writing to response")
@@ -62,36 +198,272 @@ func getExitSignal() chan os.Signal {
 		syscall.SIGTERM,
 		syscall.SIGINT,
 		syscall.SIGQUIT,
-		syscall.SIGHUP,
 	)
 	return channel
 }
 
-func startWebService() *http.Server {
+// SIGHUP asks the app to reload its configuration rather than to exit
+func getReloadSignal() chan os.Signal {
+	channel := make(chan os.Signal, 1)
+	signal.Notify(channel, syscall.SIGHUP)
+	return channel
+}
+
+// reload re-reads the configuration and log control file and rebuilds the outbound clients,
+// server certificate and client CA certificates. The server keeps running, so open connections are not dropped.
+// An invalid configuration is not applied, the previous one stays in use.
+func reload() error {
+	next := configuration.ReadAppConfig()
+	if err := next.Validate(); err != nil {
+		metric.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		log.WithError(err).Error("Configuration reload failed, keeping the previous configuration")
+		return err
+	}
+	configuration.SetAppConfig(next)
+	// the credentials may have changed, so the next request logs in again
+	tokenSource.Invalidate()
+	request.Reload()
+
+	err := errors.Join(log.Reload(), reloadCertificate(), reloadClientCAs())
+	if err != nil {
+		metric.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		log.WithError(err).Error("Configuration reload failed")
+		return err
+	}
+
+	metric.ConfigReloadsTotal.WithLabelValues("success").Inc()
+	log.Info("Configuration reloaded")
+	return nil
+}
+
+// reloadCertificate switches the HTTPS listener to the key pair currently on disk
+func reloadCertificate() error {
+	if certReloader == nil {
+		return nil
+	}
+	return certReloader.Reload()
+}
+
+// reloadClientCAs loads the CA certificates client certificates are verified with, while CLIENT_CERT_ROUTES is set
+func reloadClientCAs() error {
+	if certReloader == nil || len(configuration.Current().ClientCertRoutes) == 0 {
+		return nil
+	}
+	caCertPool, err := configuration.CACertPool()
+	if err != nil {
+		return fmt.Errorf("could not load CA certificates for client certificate verification: %w", err)
+	}
+	clientCAs.Store(caCertPool)
+	return nil
+}
+
+// handshakeConfig returns the TLS configuration of each handshake, base with client certificate verification
+// against the current CA certificates while CLIENT_CERT_ROUTES is set
+func handshakeConfig(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(configuration.Current().ClientCertRoutes) == 0 {
+			return nil, nil
+		}
+		caCertPool := clientCAs.Load()
+		if caCertPool == nil {
+			return nil, errors.New("no CA certificates to verify client certificates with")
+		}
+		config := base.Clone()
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = caCertPool
+		return config, nil
+	}
+}
+
+// watchCertificate reloads the server certificate whenever the mounted key pair changes,
+// a CERT_WATCH_INTERVAL of 0 leaves the reload to SIGHUP
+func watchCertificate(ctx context.Context) {
+	config := configuration.Current()
+	if config.CertWatchInterval <= 0 {
+		return
+	}
+	watcher.New(config.CertWatchInterval, func() {
+		if err := reloadCertificate(); err != nil {
+			log.WithError(err).Error("Server certificate rotation failed, keeping previous certificate")
+			return
+		}
+		log.Info("Server certificate rotated")
+	}, config.CertFile, config.KeyFile).Run(ctx)
+}
+
+// waitForExitSignal reloads the configuration on every SIGHUP until a termination signal arrives
+func waitForExitSignal() os.Signal {
+	for {
+		select {
+		case <-ReloadSignal:
+			log.Info("Received SIGHUP, reloading configuration")
+			_ = reload()
+		case sig := <-ExitSignal:
+			return sig
+		}
+	}
+}
+
+// handle registers handler on mux, requiring a valid bearer token when the pattern is one of JWT_ROUTES
+// and a verified client certificate when it is one of CLIENT_CERT_ROUTES
+func handle(mux *http.ServeMux, pattern string, handler http.Handler) {
+	mux.Handle(pattern, requireClientCert(pattern, requireBearerToken(pattern, withLogFields(pattern, handler))))
+}
+
+// requireClientCert requires a verified and allowed client certificate for pattern while it is one of
+// CLIENT_CERT_ROUTES, and otherwise identifies the client by the certificate it presented. The routes and
+// allowlists are read from the current configuration on every request, so they follow configuration reloads.
+func requireClientCert(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		config := configuration.Current()
+		policy := auth.ClientCertPolicy{
+			AllowedSubjects: config.ClientCertAllowedSubjects,
+			AllowedSANs:     config.ClientCertAllowedSANs,
+		}
+		for _, route := range config.ClientCertRoutes {
+			if route == pattern {
+				policy.Require(next).ServeHTTP(resp, req)
+				return
+			}
+		}
+		policy.Identify(next).ServeHTTP(resp, req)
+	})
+}
+
+// requireBearerToken requires a valid bearer token for pattern while it is one of JWT_ROUTES. The routes and
+// the token policy are read from the current configuration on every request, so they follow configuration reloads.
+func requireBearerToken(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		config := configuration.Current()
+		jwtRoutes, err := config.JWTRouteRequirements()
+		if err != nil {
+			// the configuration check reports the invalid setting, until it is fixed every route is refused
+			log.WithContext(req.Context()).WithError(err).Debug("Refused request, JWT_ROUTES is invalid")
+			http.Error(resp, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		for _, route := range jwtRoutes {
+			if route.Pattern == pattern {
+				requirement := auth.Requirement{Scopes: route.Scopes, Roles: route.Roles}
+				bearerPolicy(config).Require(requirement, next).ServeHTTP(resp, req)
+				return
+			}
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// withLogFields puts the route and method of the request on its context, for the messages logged while serving it
+func withLogFields(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ctx := log.NewContext(req.Context(), log.Fields{"route": pattern, "method": req.Method})
+		next.ServeHTTP(resp, req.WithContext(ctx))
+	})
+}
+
+// bearerPolicy validates tokens issued by the realm of the IAM config points to
+func bearerPolicy(config *configuration.Config) auth.BearerPolicy {
+	policy := auth.BearerPolicy{
+		Keys: bearerKeys,
+		Issuer: func(ctx context.Context) (string, error) {
+			return request.Issuer(ctx, config.IamBaseURL)
+		},
+		Audience:  config.JWTAudience,
+		ClockSkew: config.JWTClockSkew,
+	}
+	if config.JWTIntrospection {
+		policy.Introspect = introspectToken
+	}
+	return policy
+}
+
+// introspectToken asks the IAM for the claims of a token that is not a JWT, bounded by the configured request timeout
+func introspectToken(ctx context.Context, token string) (*auth.TokenClaims, error) {
+	config := configuration.Current()
+	if config.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.RequestTimeout)
+		defer cancel()
+	}
+	result, err := request.Introspect(ctx, config.IamClientID, config.IamClientSecret, config.IamBaseURL, token)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Active {
+		return nil, auth.ErrInactive
+	}
+	claims := &auth.TokenClaims{}
+	if err := json.Unmarshal(result.Raw, claims); err != nil {
+		return nil, fmt.Errorf("JSON Unmarshal Failed with following error: %w: %w", request.ErrDecode, err)
+	}
+	return claims, nil
+}
+
+// startWebService starts serving the routes of the app, the returned channel is closed once the server
+// has stopped and the goroutines it started have returned
+func startWebService() (*http.Server, <-chan struct{}) {
+	config := configuration.Current()
 	ctx, servercancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	done := make(chan struct{})
 
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.HandlerFor(metric.Registry, promhttp.HandlerOpts{}))
-	mux.HandleFunc("/hello", hello)
-	mux.HandleFunc("/health", health)
+	handle(mux, "/metrics", promhttp.HandlerFor(metric.Registry, promhttp.HandlerOpts{}))
+	handle(mux, "/hello", http.HandlerFunc(hello))
+	handle(mux, "/health", http.HandlerFunc(health))
+	handle(mux, "/health/live", healthChecks.Handler(healthcheck.Liveness))
+	handle(mux, "/health/ready", healthChecks.Handler(healthcheck.Readiness))
+	handle(mux, "/health/startup", healthChecks.Handler(healthcheck.Startup))
 
 	localPort := fmt.Sprintf(":%d", config.LocalPort)
 
 	server = &http.Server{
 		Addr:              localPort,
-		Handler:           mux,
+		Handler:           metric.InstrumentHandler(mux, "/hello"),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	if config.LocalProtocol == "https" {
+		reloader, err := configuration.NewCertReloader(config.CertFile, config.KeyFile)
+		if err != nil {
+			// the server listens anyway and picks up the key pair once it is valid, meanwhile the
+			// handshakes fail and the certificates check keeps the app out of service
+			log.WithError(err).Error("Server certificate cannot be used, HTTPS is refused until a valid key pair is mounted")
+		}
+		certReloader = reloader
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS13,
+		}
+		// client certificates are verified during the handshake when presented, against the CA certificates
+		// of the last reload, whether a route requires one is decided per request by handle
+		server.TLSConfig.GetConfigForClient = handshakeConfig(server.TLSConfig)
+		if err := reloadClientCAs(); err != nil {
+			log.WithError(err).Error("Client certificates cannot be verified, the web service is not started")
+			servercancel()
+			close(done)
+			return server, done
+		}
+		server.RegisterOnShutdown(servercancel)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchCertificate(ctx)
+		}()
+	}
+
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		if config.LocalProtocol == "https" {
-			err := server.ListenAndServeTLS(config.CertFile, config.KeyFile)
-			if err != nil {
+			// the key pair is served by certReloader, so no files are passed here
+			err := server.ListenAndServeTLS("", "")
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				log.Error(err.Error())
 			}
 		} else {
 			err := server.ListenAndServe()
-			if err != nil {
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				log.Error(err.Error())
 			}
 		}
@@ -99,12 +471,86 @@ func startWebService() *http.Server {
 		defer servercancel()
 	}()
 
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
 	log.Info("Server is ready to receive web requests")
 
-	return server
+	return server, done
+}
+
+// shutdown fails the health check, waits for in-flight requests to drain, revokes the IAM token and
+// flushes pending remote log entries, returning the exit code the process should terminate with
+func shutdown(srv *http.Server) int {
+	config := configuration.Current()
+	shuttingDown.Store(true)
+	log.WithField("delay", config.ShutdownDelay.String()).Info("Health check now failing, waiting before draining")
+	time.Sleep(config.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	code := exitOK
+	if err := srv.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Failed to drain in-flight requests")
+		code = exitShutdownFailed
+	}
+
+	revokeToken(ctx)
+
+	log.Info("Server stopped, flushing logs")
+	if err := log.Flush(ctx); err != nil {
+		// the remote log shipper is what failed, so only stdout can be relied on here
+		fmt.Fprintln(os.Stderr, "Failed to flush remote logs: "+err.Error())
+		code = exitShutdownFailed
+	}
+
+	return code
+}
+
+// revokeToken revokes the cached IAM token, which is not needed anymore. A failure is only logged,
+// as the token expires anyway.
+func revokeToken(ctx context.Context) {
+	config := configuration.Current()
+	token := tokenSource.Drop()
+	if token == nil {
+		return
+	}
+	if err := request.Revoke(ctx, config.IamClientID, config.IamClientSecret, config.IamBaseURL,
+		token.AccessToken, request.AccessTokenHint); err != nil {
+		log.WithError(err).Warning("Failed to revoke the IAM token")
+		return
+	}
+	if token.RefreshToken != "" {
+		if err := request.Revoke(ctx, config.IamClientID, config.IamClientSecret, config.IamBaseURL,
+			token.RefreshToken, request.RefreshTokenHint); err != nil {
+			log.WithError(err).Warning("Failed to revoke the IAM refresh token")
+			return
+		}
+	}
+	log.Info("IAM token revoked")
+}
+
+// forceExitOnSignal terminates the process immediately if another signal arrives
+// while the graceful shutdown is still in progress
+func forceExitOnSignal(signals <-chan os.Signal) {
+	sig := <-signals
+	fmt.Fprintln(os.Stderr, "Received "+sig.String()+" during shutdown, forcing exit")
+	os.Exit(exitForced)
 }
 
 func main() {
-	go startWebService()
-	<-ExitSignal //wait to receive exit signal
+	ctx, stop := context.WithCancel(context.Background())
+	tokenSource.Start(ctx)
+	go log.WatchLogControl(ctx, configuration.Current().LogControlWatchInterval)
+	srv, _ := startWebService()
+	sig := waitForExitSignal()
+	log.WithField("signal", sig.String()).Info("Received signal, shutting down")
+	// no new token is needed, the cached one is revoked during the shutdown
+	stop()
+
+	go forceExitOnSignal(ExitSignal)
+	os.Exit(shutdown(srv))
 }
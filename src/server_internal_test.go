@@ -2,20 +2,42 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"eric-oss-hello-world-go-app/src/internal/auth"
 	"eric-oss-hello-world-go-app/src/internal/configuration"
+	"eric-oss-hello-world-go-app/src/internal/fakeiam"
+	"eric-oss-hello-world-go-app/src/internal/jwt"
+	"eric-oss-hello-world-go-app/src/internal/metric"
+	"eric-oss-hello-world-go-app/src/internal/request"
+	"eric-oss-hello-world-go-app/src/internal/resilience"
 
 	log "eric-oss-hello-world-go-app/src/internal/logging"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -109,6 +131,111 @@ func TestExitSignalChannel(t *testing.T) {
 	assert.Equal(t, "chan", reflect.ValueOf(channel).Kind().String(), "Kind should be 'chan'")
 }
 
+func TestReloadSignalChannel(t *testing.T) {
+	channel := getReloadSignal()
+
+	assert.NotNil(t, channel, "Channel should not be nil")
+	assert.Equal(t, 1, cap(channel), "Capacity should be 1")
+}
+
+func restoreConfigAfterTest(t *testing.T) {
+	oldConfig := configuration.Current()
+	t.Cleanup(func() {
+		configuration.SetAppConfig(oldConfig)
+		log.Init()
+	})
+}
+
+// stopAfterTest closes srv once the test is done and waits for the goroutines of startWebService,
+// before the configuration they read is restored
+func stopAfterTest(t *testing.T, srv *http.Server, done <-chan struct{}) {
+	t.Cleanup(func() {
+		_ = srv.Close()
+		<-done
+	})
+}
+
+// useConfig makes config the current configuration for the duration of the test
+func useConfig(t *testing.T, config *configuration.Config) {
+	oldConfig := configuration.Current()
+	configuration.SetAppConfig(config)
+	t.Cleanup(func() { configuration.SetAppConfig(oldConfig) })
+}
+
+func TestReloadSucceeds(t *testing.T) {
+	restoreConfigAfterTest(t)
+	logCtrl := t.TempDir() + "/logcontrol.json"
+	err := os.WriteFile(logCtrl, []byte(`[{"severity": "debug","container": "hello"}]`), 0o600)
+	assert.Nil(t, err)
+	t.Setenv("LOG_CTRL_FILE", logCtrl)
+	t.Setenv("CONTAINER_NAME", "hello")
+	t.Setenv("IAM_CLIENT_ID", "testID")
+	t.Setenv("IAM_CLIENT_SECRET", "testSecret")
+	t.Setenv("IAM_BASE_URL", "https://iam.test")
+	before := testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("success"))
+
+	err = reload()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testID", configuration.Current().IamClientID, "Handlers should use the reloaded config")
+	assert.Equal(t, before+1, testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("success")))
+}
+
+func TestReloadFails(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Setenv("LOG_CTRL_FILE", t.TempDir()+"/missing.json")
+	t.Setenv("IAM_CLIENT_ID", "testID")
+	t.Setenv("IAM_CLIENT_SECRET", "testSecret")
+	t.Setenv("IAM_BASE_URL", "https://iam.test")
+	before := testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure"))
+
+	err := reload()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not read LogControlFile")
+	assert.Equal(t, before+1, testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure")))
+}
+
+func TestReloadKeepsConfigWhenInvalid(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Setenv("IAM_CLIENT_ID", "testID")
+	t.Setenv("IAM_CLIENT_SECRET", "testSecret")
+	t.Setenv("IAM_BASE_URL", "not a URL")
+	previous := configuration.Current()
+	before := testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure"))
+
+	err := reload()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "IAM_BASE_URL must be an absolute URL")
+	assert.Same(t, previous, configuration.Current(), "An invalid configuration should not be applied")
+	assert.Equal(t, before+1, testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure")))
+}
+
+func TestWaitForExitSignalReloadsOnSighup(t *testing.T) {
+	restoreConfigAfterTest(t)
+	oldExit, oldReload := ExitSignal, ReloadSignal
+	ExitSignal, ReloadSignal = make(chan os.Signal, 1), make(chan os.Signal, 1)
+	t.Cleanup(func() { ExitSignal, ReloadSignal = oldExit, oldReload })
+	before := testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure")) +
+		testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("success"))
+
+	ReloadSignal <- syscall.SIGHUP
+	go func() {
+		for len(ReloadSignal) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		ExitSignal <- syscall.SIGTERM
+	}()
+
+	sig := waitForExitSignal()
+
+	assert.Equal(t, syscall.SIGTERM, sig)
+	after := testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("failure")) +
+		testutil.ToFloat64(metric.ConfigReloadsTotal.WithLabelValues("success"))
+	assert.Equal(t, before+1, after, "SIGHUP should have triggered a reload")
+}
+
 func TestStartWebService(t *testing.T) {
 	retries := 3
 	var res *http.Response
@@ -121,7 +248,7 @@ func TestStartWebService(t *testing.T) {
 	log.SetOutput(wrt)
 	log.SetLevel(log.DebugLevel)
 	// act
-	srv := startWebService()
+	srv, done := startWebService()
 
 	// assert
 	assert.NotNil(t, srv, "Should not be nill")
@@ -149,6 +276,9 @@ func TestStartWebService(t *testing.T) {
 	defer cancel()
 	err = srv.Shutdown(ctxShutDown)
 	assert.Nil(t, err)
+	<-done
+	logged, _ := os.ReadFile(logOutputFileName)
+	assert.NotContains(t, string(logged), http.ErrServerClosed.Error(), "A graceful shutdown is not an error")
 	_ = os.Remove(logOutputFileName)
 }
 
@@ -157,7 +287,7 @@ func TestStartWebServiceWithHttps(t *testing.T) {
 	var res *http.Response
 	var err error
 
-	config = &configuration.Config{
+	useConfig(t, &configuration.Config{
 		LocalPort:       8050,
 		LocalProtocol:   "https",
 		CertFile:        "certificate.pem",
@@ -167,7 +297,7 @@ func TestStartWebServiceWithHttps(t *testing.T) {
 		AppKey:          "test.key",
 		AppCert:         "test.cert",
 		AppCertFilePath: "/etc/tls/log/",
-	}
+	})
 
 	file, err := os.OpenFile(logOutputFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o666)
 	assert.Nil(t, err, fmt.Sprintf("error creating file: %v", err))
@@ -176,7 +306,7 @@ func TestStartWebServiceWithHttps(t *testing.T) {
 	log.SetOutput(wrt)
 	log.SetLevel(log.DebugLevel)
 	// act
-	srv := startWebService()
+	srv, done := startWebService()
 
 	// assert
 	assert.NotNil(t, srv, "Should not be nil")
@@ -198,5 +328,630 @@ func TestStartWebServiceWithHttps(t *testing.T) {
 	defer cancel()
 	err = srv.Shutdown(ctxShutDown)
 	assert.Nil(t, err)
+	<-done
 	_ = os.Remove(logOutputFileName)
 }
+
+func TestHealthFailsWhileShuttingDown(t *testing.T) {
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	request := httptest.NewRequest(http.MethodGet, "/health", nil)
+	response := httptest.NewRecorder()
+
+	health(response, request)
+
+	res := response.Result()
+	defer res.Body.Close() //nolint:errcheck //error has no impact
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	data, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "Shutting down", string(data))
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	useConfig(t, &configuration.Config{ShutdownTimeout: 5 * time.Second})
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+	})
+
+	started := make(chan struct{})
+	router := http.NewServeMux()
+	router.HandleFunc("/slow", func(resp http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		_, _ = fmt.Fprintf(resp, "done")
+	})
+	svr := httptest.NewServer(router)
+
+	result := make(chan string, 1)
+	go func() {
+		res, err := http.Get(svr.URL + "/slow")
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer res.Body.Close() //nolint:errcheck //error has no impact
+		data, _ := io.ReadAll(res.Body)
+		result <- string(data)
+	}()
+	<-started
+
+	// act
+	code := shutdown(svr.Config)
+
+	// assert
+	assert.Equal(t, exitOK, code)
+	assert.True(t, shuttingDown.Load(), "Health check should be failing after shutdown")
+	assert.Equal(t, "done", <-result, "In-flight request should complete")
+}
+
+func TestShutdownTimesOut(t *testing.T) {
+	useConfig(t, &configuration.Config{ShutdownTimeout: 50 * time.Millisecond})
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+	})
+
+	started := make(chan struct{})
+	router := http.NewServeMux()
+	router.HandleFunc("/stuck", func(resp http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	})
+	svr := httptest.NewServer(router)
+	go func() {
+		res, err := http.Get(svr.URL + "/stuck")
+		if err == nil {
+			res.Body.Close() //nolint:errcheck,gosec //error has no impact
+		}
+	}()
+	<-started
+
+	// act
+	code := shutdown(svr.Config)
+	close(release)
+
+	// assert
+	assert.Equal(t, exitShutdownFailed, code)
+}
+
+// writeTestKeyPair writes a self-signed certificate for commonName and its key into dir
+func writeTestKeyPair(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	assert.Nil(t, err)
+	privBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0o600)
+	assert.Nil(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0o600)
+	assert.Nil(t, err)
+
+	return certFile, keyFile
+}
+
+func servedCommonName(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec //only the served certificate is inspected
+		MinVersion:         tls.VersionTLS13,
+	})
+	if err != nil {
+		return ""
+	}
+	defer conn.Close() //nolint:errcheck //error has no impact
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestStartWebServiceRotatesCertificate(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(func() { certReloader = nil })
+	dir := t.TempDir()
+	certFile, keyFile := writeTestKeyPair(t, dir, "first.test")
+	useConfig(t, &configuration.Config{
+		LocalPort:         8051,
+		LocalProtocol:     "https",
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		CertWatchInterval: 10 * time.Millisecond,
+	})
+
+	srv, done := startWebService()
+	stopAfterTest(t, srv, done)
+
+	assert.Eventually(t, func() bool { return servedCommonName(t, "localhost:8051") == "first.test" },
+		5*time.Second, 10*time.Millisecond, "Initial certificate should be served")
+
+	writeTestKeyPair(t, dir, "second.test")
+
+	assert.Eventually(t, func() bool { return servedCommonName(t, "localhost:8051") == "second.test" },
+		5*time.Second, 10*time.Millisecond, "Rotated certificate should be served without a restart")
+}
+
+func TestStartWebServiceWaitsForValidCertificate(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(func() { certReloader = nil })
+	dir := t.TempDir()
+	useConfig(t, &configuration.Config{
+		LocalPort:         8053,
+		LocalProtocol:     "https",
+		CertFile:          filepath.Join(dir, "tls.crt"),
+		KeyFile:           filepath.Join(dir, "tls.key"),
+		CertWatchInterval: 10 * time.Millisecond,
+	})
+
+	srv, done := startWebService()
+	stopAfterTest(t, srv, done)
+
+	assert.NotNil(t, checkCertificates(context.Background()), "The certificates check should keep the app out of service")
+
+	writeTestKeyPair(t, dir, "mounted.test")
+
+	assert.Eventually(t, func() bool { return servedCommonName(t, "localhost:8053") == "mounted.test" },
+		5*time.Second, 10*time.Millisecond, "The server should listen and serve the key pair once it is mounted")
+	assert.Nil(t, checkCertificates(context.Background()))
+}
+
+func TestStartWebServiceRequiresClientCertOnConfiguredRoutes(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(func() {
+		certReloader = nil
+		clientCAs.Store(nil)
+	})
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir(), "server.test")
+	clientDir := t.TempDir()
+	clientCertFile, clientKeyFile := writeTestKeyPair(t, clientDir, "client.test")
+	useConfig(t, &configuration.Config{
+		LocalPort:                 8052,
+		LocalProtocol:             "https",
+		CertFile:                  certFile,
+		KeyFile:                   keyFile,
+		CaCertFilePath:            clientDir,
+		CaCertFileName:            "tls.crt",
+		CertWatchInterval:         time.Minute,
+		ClientCertRoutes:          []string{"/hello"},
+		ClientCertAllowedSubjects: []string{"client.test"},
+	})
+
+	srv, done := startWebService()
+	stopAfterTest(t, srv, done)
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.Nil(t, err)
+	get := func(path string, certs ...tls.Certificate) int {
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec //the server certificate is not under test
+			Certificates:       certs,
+			MinVersion:         tls.VersionTLS13,
+		}}}
+		res, err := client.Get("https://localhost:8052" + path)
+		if err != nil {
+			return 0
+		}
+		defer res.Body.Close() //nolint:errcheck //error has no impact
+		return res.StatusCode
+	}
+
+	assert.Eventually(t, func() bool { return get("/health") == http.StatusOK },
+		5*time.Second, 10*time.Millisecond, "Health should not require a client certificate")
+	assert.Equal(t, http.StatusUnauthorized, get("/hello"))
+	assert.Equal(t, http.StatusOK, get("/hello", clientCert))
+}
+
+func TestStartWebServiceFollowsReloadedClientCertSettings(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(func() {
+		certReloader = nil
+		clientCAs.Store(nil)
+	})
+	certFile, keyFile := writeTestKeyPair(t, t.TempDir(), "server.test")
+	firstDir, secondDir := t.TempDir(), t.TempDir()
+	firstCertFile, firstKeyFile := writeTestKeyPair(t, firstDir, "first.test")
+	secondCertFile, secondKeyFile := writeTestKeyPair(t, secondDir, "second.test")
+	config := configuration.Config{
+		LocalPort:                 8054,
+		LocalProtocol:             "https",
+		CertFile:                  certFile,
+		KeyFile:                   keyFile,
+		CaCertFilePath:            firstDir,
+		CaCertFileName:            "tls.crt",
+		CertWatchInterval:         time.Minute,
+		ClientCertRoutes:          []string{"/hello"},
+		ClientCertAllowedSubjects: []string{"first.test"},
+	}
+	useConfig(t, &config)
+
+	srv, done := startWebService()
+	stopAfterTest(t, srv, done)
+
+	firstCert, err := tls.LoadX509KeyPair(firstCertFile, firstKeyFile)
+	assert.Nil(t, err)
+	secondCert, err := tls.LoadX509KeyPair(secondCertFile, secondKeyFile)
+	assert.Nil(t, err)
+	get := func(cert tls.Certificate) int {
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec //the server certificate is not under test
+			Certificates:       []tls.Certificate{cert},
+			MinVersion:         tls.VersionTLS13,
+		}}}
+		res, err := client.Get("https://localhost:8054/hello")
+		if err != nil {
+			return 0
+		}
+		defer res.Body.Close() //nolint:errcheck //error has no impact
+		return res.StatusCode
+	}
+
+	assert.Eventually(t, func() bool { return get(firstCert) == http.StatusOK },
+		5*time.Second, 10*time.Millisecond, "The allowed client certificate should be accepted")
+
+	allowNobody := config
+	allowNobody.ClientCertAllowedSubjects = []string{"nobody.test"}
+	configuration.SetAppConfig(&allowNobody)
+	assert.Equal(t, http.StatusForbidden, get(firstCert), "A changed allowlist should apply without a restart")
+
+	rotated := config
+	rotated.CaCertFilePath = secondDir
+	rotated.ClientCertAllowedSubjects = []string{"second.test"}
+	configuration.SetAppConfig(&rotated)
+	assert.Nil(t, reloadClientCAs())
+	assert.Equal(t, http.StatusUnauthorized, get(firstCert), "A certificate of the previous CA should not be verified")
+	assert.Equal(t, http.StatusOK, get(secondCert), "A certificate of the rotated CA should be accepted")
+}
+
+func TestInstrumentedRoutesPopulateMetrics(t *testing.T) {
+	restoreConfigAfterTest(t)
+	useConfig(t, &configuration.Config{})
+	mux := http.NewServeMux()
+	handle(mux, "/hello", http.HandlerFunc(hello))
+	handle(mux, "/health", http.HandlerFunc(health))
+	svr := httptest.NewServer(metric.InstrumentHandler(mux, "/hello"))
+	defer svr.Close()
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	requestsBefore := testutil.ToFloat64(metric.RequestsTotal)
+	failedBefore := testutil.ToFloat64(metric.RequestsFailedTotal)
+	unavailableBefore := testutil.ToFloat64(metric.HelloWorldHTTPRequestsTotal.WithLabelValues("503"))
+	helloBefore := testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("/hello", "GET", "200"))
+	unmatchedBefore := testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("unmatched", "GET", "404"))
+	healthBefore := testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("/health", "GET", "503"))
+
+	for _, path := range []string{"/hello", "/health", "/unknown"} {
+		res, err := http.Get(svr.URL + path)
+		assert.Nil(t, err)
+		res.Body.Close() //nolint:errcheck,gosec //error has no impact
+	}
+
+	assert.Equal(t, requestsBefore+1, testutil.ToFloat64(metric.RequestsTotal), "Only /hello is an API request")
+	assert.Equal(t, failedBefore, testutil.ToFloat64(metric.RequestsFailedTotal), "A failing probe is not a failed request")
+	assert.Equal(t, unavailableBefore, testutil.ToFloat64(metric.HelloWorldHTTPRequestsTotal.WithLabelValues("503")))
+	assert.Equal(t, healthBefore+1, testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("/health", "GET", "503")))
+	assert.Equal(t, helloBefore+1, testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("/hello", "GET", "200")))
+	assert.Equal(t, unmatchedBefore+1,
+		testutil.ToFloat64(metric.HTTPRequestsByRouteTotal.WithLabelValues("unmatched", "GET", "404")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metric.HTTPRequestsInFlight.WithLabelValues("/hello")))
+	for _, histogram := range []*prometheus.HistogramVec{
+		metric.HTTPRequestDurationSeconds, metric.HTTPRequestSizeBytes, metric.HTTPResponseSizeBytes,
+	} {
+		assert.Equal(t, 3, testutil.CollectAndCount(histogram), "Every route should have been observed")
+	}
+}
+
+func TestHelloReusesCachedToken(t *testing.T) {
+	restoreConfigAfterTest(t)
+	var logins int
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		logins++
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"testToken","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer iam.Close()
+	useConfig(t, &configuration.Config{IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL})
+	tokenSource.Invalidate()
+	t.Cleanup(tokenSource.Invalidate)
+
+	for i := 0; i < 3; i++ {
+		hello(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	}
+
+	assert.Equal(t, 1, logins, "Only the first request should log in")
+}
+
+func TestHelloCountsLoginFailures(t *testing.T) {
+	restoreConfigAfterTest(t)
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer iam.Close()
+	useConfig(t, &configuration.Config{IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL,
+		IamRetryMaxAttempts: 3})
+	tokenSource.Invalidate()
+	before := testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("iam", "http_503"))
+	retriesBefore := testutil.ToFloat64(metric.UpstreamRetriesTotal.WithLabelValues("iam", "http_503"))
+
+	response := httptest.NewRecorder()
+	hello(response, httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("iam", "http_503")))
+	assert.Equal(t, retriesBefore+2,
+		testutil.ToFloat64(metric.UpstreamRetriesTotal.WithLabelValues("iam", "http_503")))
+}
+
+func TestRecordBreakerState(t *testing.T) {
+	t.Cleanup(func() { recordBreakerState("iam_token", resilience.StateClosed) })
+
+	recordBreakerState("iam_token", resilience.StateOpen)
+
+	assert.Equal(t, 0.0, testutil.ToFloat64(metric.CircuitBreakerState.WithLabelValues("iam_token", "closed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric.CircuitBreakerState.WithLabelValues("iam_token", "open")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metric.CircuitBreakerState.WithLabelValues("iam_token", "half_open")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric.CircuitBreakerState.WithLabelValues("log_endpoint", "closed")),
+		"The breakers of other targets should keep their state")
+}
+
+func TestWithLogFields(t *testing.T) {
+	var output strings.Builder
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stdout) })
+	handler := withLogFields("/hello", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		log.WithContext(req.Context()).Info("serving")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/hello", nil))
+
+	assert.Contains(t, output.String(), "method=POST")
+	assert.Contains(t, output.String(), "route=/hello")
+}
+
+func TestRecordLogLevel(t *testing.T) {
+	t.Cleanup(func() { recordLogLevel(log.Severity(log.Level())) })
+
+	recordLogLevel("debug")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metric.LogLevel.WithLabelValues("debug")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metric.LogLevel.WithLabelValues("info")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metric.LogLevel.WithLabelValues("critical")))
+}
+
+func TestHelloBoundsLoginByRequestTimeout(t *testing.T) {
+	restoreConfigAfterTest(t)
+	release := make(chan struct{})
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer iam.Close()
+	defer close(release)
+	useConfig(t, &configuration.Config{IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL,
+		RequestTimeout: 50 * time.Millisecond})
+	tokenSource.Invalidate()
+	before := testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("iam", "timeout"))
+
+	start := time.Now()
+	response := httptest.NewRecorder()
+	hello(response, httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, before+1, testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("iam", "timeout")))
+}
+
+func TestHelloStopsWhenClientGoesAway(t *testing.T) {
+	restoreConfigAfterTest(t)
+	release := make(chan struct{})
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer iam.Close()
+	defer close(release)
+	useConfig(t, &configuration.Config{IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL})
+	tokenSource.Invalidate()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	response := httptest.NewRecorder()
+	hello(response, httptest.NewRequest(http.MethodGet, "/hello", nil).WithContext(ctx))
+
+	assert.Empty(t, response.Body.String(), "Nothing should be written to a client that went away")
+}
+
+func TestRecordAPICall(t *testing.T) {
+	before := testutil.ToFloat64(metric.UpstreamRequestsTotal.WithLabelValues("items", "GET", "200"))
+	timeoutsBefore := testutil.ToFloat64(metric.UpstreamRequestsTotal.WithLabelValues("items", "GET", "timeout"))
+	failuresBefore := testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("items", "timeout"))
+	tooLargeBefore := testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("items", "too_large"))
+
+	recordAPICall("items", "GET", http.StatusOK, time.Millisecond, nil)
+	recordAPICall("items", "GET", 0, time.Second, request.ErrTimeout)
+	recordAPICall("items", "GET", http.StatusOK, time.Millisecond, request.ErrResponseTooLarge)
+
+	assert.Equal(t, before+2, testutil.ToFloat64(metric.UpstreamRequestsTotal.WithLabelValues("items", "GET", "200")))
+	assert.Equal(t, tooLargeBefore+1, testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("items", "too_large")))
+	assert.Equal(t, timeoutsBefore+1, testutil.ToFloat64(metric.UpstreamRequestsTotal.WithLabelValues("items", "GET", "timeout")))
+	assert.Equal(t, failuresBefore+1, testutil.ToFloat64(metric.UpstreamFailuresTotal.WithLabelValues("items", "timeout")))
+}
+
+func TestHandleRequiresBearerTokenOnConfiguredRoutes(t *testing.T) {
+	restoreConfigAfterTest(t)
+	oldKeys := bearerKeys
+	t.Cleanup(func() { bearerKeys = oldKeys })
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/auth/realms/master/protocol/openid-connect/certs" {
+			_ = json.NewEncoder(rw).Encode(jwt.JWKS{Keys: []jwt.JWK{{
+				KeyType: "EC", KeyID: "key1", Curve: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				Y: base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			}}})
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"testToken","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer iam.Close()
+	useConfig(t, &configuration.Config{
+		IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL,
+		JWTRoutes: []string{"/hello=role:reader"},
+	})
+	bearerKeys = auth.NewKeySet(fetchSigningKeys)
+	tokenSource.Invalidate()
+	t.Cleanup(tokenSource.Invalidate)
+	mux := http.NewServeMux()
+	handle(mux, "/hello", http.HandlerFunc(hello))
+	handle(mux, "/health", http.HandlerFunc(health))
+	token := func(roles ...string) string {
+		token, err := jwt.Sign(jwt.Header{KeyID: "key1"}, map[string]any{
+			"iss":          iam.URL + "/auth/realms/master",
+			"sub":          "user-1",
+			"exp":          time.Now().Add(time.Minute).Unix(),
+			"realm_access": map[string]any{"roles": roles},
+		}, key)
+		assert.Nil(t, err)
+		return token
+	}
+	get := func(path, token string) int {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/health", ""), "Health should not require a token")
+	assert.Equal(t, http.StatusUnauthorized, get("/hello", ""))
+	assert.Equal(t, http.StatusForbidden, get("/hello", token()))
+	assert.Equal(t, http.StatusOK, get("/hello", token("reader")))
+}
+
+func TestHandleRefusesRoutesWhenJWTRoutesAreInvalid(t *testing.T) {
+	restoreConfigAfterTest(t)
+	useConfig(t, &configuration.Config{JWTRoutes: []string{"/hello=group:admins"}})
+	mux := http.NewServeMux()
+	handle(mux, "/health", http.HandlerFunc(health))
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
+
+func TestHandleFollowsReloadedAudience(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(request.Reload)
+	iam := fakeiam.NewServer()
+	defer iam.Close()
+	iam.AddClient("testID", fakeiam.Client{Secret: "testSecret"})
+	oldKeys := bearerKeys
+	t.Cleanup(func() { bearerKeys = oldKeys })
+	bearerKeys = auth.NewKeySet(fetchSigningKeys)
+	logCtrl := t.TempDir() + "/logcontrol.json"
+	assert.Nil(t, os.WriteFile(logCtrl, []byte(`[{"severity": "info","container": "hello"}]`), 0o600))
+	t.Setenv("LOG_CTRL_FILE", logCtrl)
+	t.Setenv("CONTAINER_NAME", "hello")
+	t.Setenv("IAM_CLIENT_ID", "testID")
+	t.Setenv("IAM_CLIENT_SECRET", "testSecret")
+	t.Setenv("IAM_BASE_URL", iam.URL)
+	t.Setenv("JWT_ROUTES", "/hello")
+	t.Setenv("JWT_AUDIENCE", "hello-old")
+	assert.Nil(t, reload())
+	tokenSource.Invalidate()
+	t.Cleanup(tokenSource.Invalidate)
+	mux := http.NewServeMux()
+	handle(mux, "/hello", http.HandlerFunc(hello))
+	get := func(audience string) int {
+		request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		request.Header.Set("Authorization", "Bearer "+iam.IssueToken(map[string]any{"aud": audience}))
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusOK, get("hello-old"))
+
+	t.Setenv("JWT_AUDIENCE", "hello-new")
+	assert.Nil(t, reload())
+
+	assert.Equal(t, http.StatusUnauthorized, get("hello-old"), "The audience of the previous configuration should be rejected")
+	assert.Equal(t, http.StatusOK, get("hello-new"))
+}
+
+func TestShutdownRevokesCachedToken(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	revoked := make(chan string, 2)
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/auth/realms/master/protocol/openid-connect/revoke" {
+			revoked <- req.FormValue("token_type_hint") + ":" + req.FormValue("token")
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token":"testToken","refresh_token":"testRefresh","expires_in":300}`))
+	}))
+	defer iam.Close()
+	useConfig(t, &configuration.Config{
+		IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL, ShutdownTimeout: time.Second,
+	})
+	tokenSource.Invalidate()
+	t.Cleanup(tokenSource.Invalidate)
+	_, err := tokenSource.Token(context.Background())
+	assert.Nil(t, err)
+
+	code := shutdown(httptest.NewServer(http.NotFoundHandler()).Config)
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "access_token:testToken", <-revoked)
+	assert.Equal(t, "refresh_token:testRefresh", <-revoked)
+	assert.Nil(t, tokenSource.Drop(), "The revoked token should not be cached anymore")
+}
+
+func TestHandleIntrospectsOpaqueTokens(t *testing.T) {
+	restoreConfigAfterTest(t)
+	t.Cleanup(request.Reload)
+	iam := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path != "/auth/realms/master/protocol/openid-connect/token/introspect":
+			_, _ = rw.Write([]byte(`{"access_token":"testToken","expires_in":300}`))
+		case req.FormValue("token") == "opaque-reader":
+			_, _ = rw.Write([]byte(`{"active":true,"sub":"user-1","realm_access":{"roles":["reader"]}}`))
+		default:
+			_, _ = rw.Write([]byte(`{"active":false}`))
+		}
+	}))
+	defer iam.Close()
+	useConfig(t, &configuration.Config{
+		IamClientID: "testID", IamClientSecret: "testSecret", IamBaseURL: iam.URL,
+		JWTRoutes: []string{"/hello=role:reader"}, JWTIntrospection: true,
+	})
+	tokenSource.Invalidate()
+	t.Cleanup(tokenSource.Invalidate)
+	mux := http.NewServeMux()
+	handle(mux, "/hello", http.HandlerFunc(hello))
+	get := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("opaque-reader"))
+	assert.Equal(t, http.StatusUnauthorized, get("opaque-revoked"))
+}